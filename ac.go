@@ -10,6 +10,7 @@ package sangfor
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/json"
 	"errors"
@@ -19,6 +20,7 @@ import (
 	"log"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 )
@@ -72,14 +74,28 @@ const (
 // NewAC 创建深信服AC操作对象,target为ip+端口,secret为AC上配置的密钥
 // e.g: target=192.168.1.1:9999(默认端口为9999), secret=YR9nQngmvhX&9BE83K
 func NewAC(target, secret string) *AC {
-	ac := &AC{secret: secret, baseUrl: fmt.Sprintf("http://%s/v1/", target), ErrLangCN: true}
+	ac := &AC{secret: secret, baseUrl: fmt.Sprintf("http://%s/v1/", target), ErrLangCN: true, clock: &acClock{}, firmware: &acFirmware{}}
 	return ac
 }
 
 type AC struct {
 	baseUrl   string
 	secret    string
-	ErrLangCN bool // 是否设置返回错误信息为中文
+	ErrLangCN bool           // 是否设置返回错误信息为中文
+	Location  *time.Location // 设备所在时区,AC返回的时间均不带时区,为空时使用本地时区
+	clock     *acClock       // 设备时钟偏移缓存
+	firmware  *acFirmware    // 设备固件版本缓存
+
+	Audit       AuditSink // 变更操作审计输出,为空时不审计
+	AuditState  bool      // 审计时是否获取变更前后的状态(会额外产生查询请求)
+	AuditStrict bool      // 审计记录写入失败时是否返回错误
+	Metrics     Metrics   // 客户端请求指标,为空时不统计
+
+	CheckPolicies bool // 设置用户/组策略前是否校验策略名(每次额外查询策略列表,可配合 NewCache 缓存)
+
+	interceptors []Interceptor // 请求拦截器,见 Use
+	bbc          *acBBC        // BBC中心端模式,见 NewBBC
+	ctx          context.Context
 }
 
 // GetVersion 获取版本信息
@@ -425,6 +441,9 @@ func (ac *AC) GetAppRank(filter ...AppRankFilter) ([]AppRank, error) {
 
 // GetBandwidthUsage 获取带宽使用率
 func (ac *AC) GetBandwidthUsage() (int, error) {
+	if err := ac.require(CapBandwidthUsage); err != nil {
+		return 0, err
+	}
 	dataBytes, err := ac.send(&acReq{uri: ac.baseUrl + acStatusBandwidthUsage, method: acGet})
 	if err != nil {
 		return 0, err
@@ -456,13 +475,7 @@ type UserAdd struct {
 		Password   string `json:"password,omitempty"`    // 本地密码
 		ModifyOnce bool   `json:"modify_once,omitempty"` // 初次认证是否修改密码
 	} `json:"self_pass,omitempty"`
-	BindCfg []struct { // 用户绑定,可同时添加多条,支持IP、MAC、out_time: 绑定有效期(不需要时可去掉此条)
-		Ip       string `json:"ip,omitempty"`       // e.g:192.168.1.2
-		Mac      string `json:"mac,omitempty"`      // e.g:ac-ed-ee-ee-ee-ee
-		OutTime  string `json:"out_time,omitempty"` // 过期时间(e.g:2019-10-31)
-		Bindgoal string `json:"bindgoal,omitempty"` // 绑定方式(noauth:免认证,loginlimit:限制登录,noauth_and_loginlimit:免认证且限制登录)
-		Desc     string `json:"desc,omitempty"`     // 绑定描述
-	} `json:"bind_cfg,omitempty"`
+	BindCfg    []UserBindCfg `json:"bind_cfg,omitempty"` // 用户绑定,可同时添加多条,支持IP、MAC、out_time: 绑定有效期(不需要时可去掉此条)
 	CommonUser *struct {
 		AllowChange bool `json:"allow_change,omitempty"` // 是否允许修改本地密码
 		Enable      bool `json:"enable,omitempty"`       // 是否允许多人使用该账号登录
@@ -470,7 +483,17 @@ type UserAdd struct {
 	CustomCfg map[string]string `json:"custom_cfg,omitempty"` // 自定义属性的键值对(e.g:{"attr1": "value1","attr2": "value2"})
 }
 
+// UserBindCfg 添加用户时的IP/MAC绑定
+type UserBindCfg struct {
+	Ip       string `json:"ip,omitempty"`       // e.g:192.168.1.2
+	Mac      string `json:"mac,omitempty"`      // e.g:ac-ed-ee-ee-ee-ee
+	OutTime  string `json:"out_time,omitempty"` // 过期时间(e.g:2019-10-31)
+	Bindgoal string `json:"bindgoal,omitempty"` // 绑定方式(noauth:免认证,loginlimit:限制登录,noauth_and_loginlimit:免认证且限制登录)
+	Desc     string `json:"desc,omitempty"`     // 绑定描述
+}
+
 // UserAdd 添加新用户
+// LimitIpmac与BindCfg中的地址会在发送前校验并规范化为AC格式
 func (ac *AC) UserAdd(data UserAdd) (string, error) {
 	var (
 		req      = &acReq{uri: ac.baseUrl + acUser, method: acPost}
@@ -479,6 +502,9 @@ func (ac *AC) UserAdd(data UserAdd) (string, error) {
 	if data.Name == "" {
 		return "", errors.New("cannot add user without username")
 	}
+	if err := data.normalize(); err != nil {
+		return "", err
+	}
 	jb, err := json.Marshal(data)
 	if err != nil {
 		return "", err
@@ -587,6 +613,7 @@ type UserSearch struct {
 }
 
 // UserSearch 搜索用户
+// 搜索值会在发送前按搜索类型校验,ip类型支持传入 IPRange 或字符串IP段(e.g:1.1.1.1-1.1.1.10)
 func (ac *AC) UserSearch(data UserSearch) ([]UserDetail, error) {
 	var (
 		req = &acReq{
@@ -596,6 +623,9 @@ func (ac *AC) UserSearch(data UserSearch) ([]UserDetail, error) {
 		}
 		postData = make(map[string]interface{})
 	)
+	if err := data.normalize(); err != nil {
+		return nil, err
+	}
 	jb, err := json.Marshal(data)
 	if err != nil {
 		return nil, err
@@ -717,14 +747,21 @@ type UserPolicySet struct {
 	Opr    string   `json:"opr"`    // 操作(add:在原策略增加,del:在原有策略删除,modify:将 策略设置为policy字段所指定的,会清除原有策略)
 	User   string   `json:"user"`   // 需要修改策略的用户
 	Policy []string `json:"policy"` // 策略名列表
+	Force  bool     `json:"-"`      // 跳过策略名校验(允许未知,禁用或过期的策略)
 }
 
 // UserNetPolicySet 设置用户的上网策略,返回成功提示或错误
+// 设置 CheckPolicies 时发送前校验策略名,未知,禁用或过期的策略返回 *PolicyNameError
 func (ac *AC) UserNetPolicySet(set UserPolicySet) (string, error) {
 	var (
 		req      = &acReq{uri: ac.baseUrl + acUserNetPolicy, method: acPost}
 		postData = make(map[string]interface{})
 	)
+	if ac.CheckPolicies && !set.Force {
+		if err := ac.checkNetPolicies(set.Opr, set.Policy); err != nil {
+			return "", err
+		}
+	}
 	jb, err := json.Marshal(set)
 	if err != nil {
 		return "", err
@@ -786,17 +823,19 @@ func (ac *AC) UserNetPolicySet(set UserPolicySet) (string, error) {
 //}
 
 // UserNetPolicyGet 获取用户关联的策略列表
-// FIXME:单元测试报错(请求的接口数据格式不正确!),需联系厂家获取正确参数
+// 文档中的参数格式会被拒绝(请求的接口数据格式不正确!),依次尝试查询参数,请求体(POST+_method=GET)等格式,
+// 均被拒绝或设备版本不支持时由 UserGet 返回的用户策略得出
 func (ac *AC) UserNetPolicyGet(username string) ([]string, error) {
-	var req = &acReq{
-		uri:    ac.baseUrl + acUserNetPolicy,
-		method: acGet,
-		Query:  map[string]string{"user": username},
+	if ac.require(CapPolicyQuery) != nil {
+		return ac.userNetPolicyFallback(username)
 	}
-	dataBytes, err := ac.send(req)
+	dataBytes, rejected, err := ac.sendAny(acRetryRejected, ac.policyGetReqs(ac.baseUrl+acUserNetPolicy, "user", username)...)
 	if err != nil {
 		return nil, err
 	}
+	if rejected {
+		return ac.userNetPolicyFallback(username)
+	}
 	if len(dataBytes) == 0 {
 		return nil, errors.New(acErrNoData)
 	}
@@ -812,12 +851,17 @@ func (ac *AC) UserNetPolicyGet(username string) ([]string, error) {
 	return r, nil
 }
 
-// UserFluxPolicySet 设置用户流控策略
+// UserFluxPolicySet 设置用户流控策略,设置 CheckPolicies 时发送前校验策略名,未知或禁用的策略返回 *PolicyNameError
 func (ac *AC) UserFluxPolicySet(set UserPolicySet) (string, error) {
 	var (
 		req      = &acReq{uri: ac.baseUrl + acUserFluxPolicy, method: acPost}
 		postData = make(map[string]interface{})
 	)
+	if ac.CheckPolicies && !set.Force {
+		if err := ac.checkFluxPolicies(set.Opr, set.Policy); err != nil {
+			return "", err
+		}
+	}
 	jb, err := json.Marshal(set)
 	if err != nil {
 		return "", err
@@ -846,12 +890,18 @@ func (ac *AC) UserFluxPolicySet(set UserPolicySet) (string, error) {
 }
 
 // UserFluxPolicyGet 传入用户名获取其关联的策略列表
-// FIXME:单元测试报错(请求的接口数据格式不正确!),需联系厂家获取正确参数
+// 请求格式同 UserNetPolicyGet,均被拒绝或设备版本不支持时由流控策略的适用对象(用户名或用户路径)得出
 func (ac *AC) UserFluxPolicyGet(username string) ([]string, error) {
-	dataBytes, err := ac.send(&acReq{uri: ac.baseUrl + acUserFluxPolicy, method: acGet, Query: map[string]string{"user": username}})
+	if ac.require(CapPolicyQuery) != nil {
+		return ac.userFluxPolicyFallback(username)
+	}
+	dataBytes, rejected, err := ac.sendAny(acRetryRejected, ac.policyGetReqs(ac.baseUrl+acUserFluxPolicy, "user", username)...)
 	if err != nil {
 		return nil, err
 	}
+	if rejected {
+		return ac.userFluxPolicyFallback(username)
+	}
 	if len(dataBytes) == 0 {
 		return nil, errors.New(acErrNoData)
 	}
@@ -868,26 +918,35 @@ func (ac *AC) UserFluxPolicyGet(username string) ([]string, error) {
 }
 
 // UserVerifyPassword 验证本地用户密码
-// FIXME: 单元测试失败,接口文档有问题,实际调用的是获取用户详细信息接口
+// 文档中的GET请求实际调用的是获取用户详细信息接口,改为POST+_method=verify,密码只放在请求体中,
+// 设备不支持(返回格式错误或用户详情)时返回 ErrUnsupported
 func (ac *AC) UserVerifyPassword(username, password string) error {
-	var req = &acReq{
+	if err := ac.require(CapUserVerify); err != nil {
+		return err
+	}
+	req := &acReq{
 		uri:    ac.baseUrl + acUser,
-		method: acGet,
-		Query: map[string]string{
-			"_method":  "verify",
-			"name":     username,
-			"password": password,
-		},
+		method: acPost,
+		Query:  map[string]string{"_method": "verify"},
+		Data:   map[string]interface{}{"name": username, "password": password},
 	}
-	dataBytes, err := ac.send(req)
+	dataBytes, rejected, err := ac.sendAny(func(resp *acResp) bool {
+		if detail, ok := resp.Data.(map[string]interface{}); ok && resp.Code == 0 {
+			_, ok = detail["name"] // 返回用户详情,未进行验证
+			return ok
+		}
+		return acRetryRejected(resp)
+	}, req)
 	if err != nil {
 		return err
 	}
+	if rejected {
+		return fmt.Errorf("%w: password verification", ErrUnsupported)
+	}
 	if len(dataBytes) == 0 {
 		return errors.New(acErrNoData)
 	}
-	var r []string
-	var resp = &acResp{Data: &r}
+	var resp = &acResp{}
 	err = json.Unmarshal(dataBytes, resp)
 	if err != nil {
 		return err
@@ -988,14 +1047,21 @@ type GroupPolicySet struct {
 	Opr    string   `json:"opr"`    // 操作(add:在原策略增加,del:在原有策略删除,modify:将 策略设置为policy字段所指定的,会清除原有策略)
 	Group  string   `json:"group"`  // 需要修改策略的组
 	Policy []string `json:"policy"` // 策略名列表
+	Force  bool     `json:"-"`      // 跳过策略名校验(允许未知,禁用或过期的策略)
 }
 
 // GroupNetPolicySet 指定/修改/删除组关联的上网策略
+// 设置 CheckPolicies 时发送前校验策略名,未知,禁用或过期的策略返回 *PolicyNameError
 func (ac *AC) GroupNetPolicySet(plc GroupPolicySet) (string, error) {
 	var req = &acReq{
 		uri:    ac.baseUrl + acGroupNetPolicy,
 		method: acPost,
 	}
+	if ac.CheckPolicies && !plc.Force {
+		if err := ac.checkNetPolicies(plc.Opr, plc.Policy); err != nil {
+			return "", err
+		}
+	}
 	plcJson, err := json.Marshal(plc)
 	if err != nil {
 		return "", err
@@ -1023,19 +1089,18 @@ func (ac *AC) GroupNetPolicySet(plc GroupPolicySet) (string, error) {
 }
 
 // GroupNetPolicyGet 获取对应组关联的上网策略
-// FIXME:单元测试报错(请求的接口数据格式不正确!),需联系厂家获取正确参数
+// 请求格式同 UserNetPolicyGet,均被拒绝或设备版本不支持时由上网策略关联的组织结构得出(只包括直接关联的策略)
 func (ac *AC) GroupNetPolicyGet(path string) ([]string, error) {
-	var req = &acReq{
-		uri:    ac.baseUrl + acGroupNetPolicy,
-		method: acGet,
-		Query: map[string]string{
-			"path": path,
-		},
+	if ac.require(CapPolicyQuery) != nil {
+		return ac.groupNetPolicyFallback(path)
 	}
-	dataBytes, err := ac.send(req)
+	dataBytes, rejected, err := ac.sendAny(acRetryRejected, ac.policyGetReqs(ac.baseUrl+acGroupNetPolicy, "path", path)...)
 	if err != nil {
 		return nil, err
 	}
+	if rejected {
+		return ac.groupNetPolicyFallback(path)
+	}
 	if len(dataBytes) == 0 {
 		return nil, errors.New(acErrNoData)
 	}
@@ -1153,32 +1218,41 @@ func (ac *AC) PolicyFluxGet() ([]FluxPolicy, error) {
 	return r, nil
 }
 
-// BindUserSearch 查询用户和IP/MAC的绑定关系(支持按用户名,IP,MAC进行搜索)
-// FIXME:单元测试报错(请求的数据格式不正确!),需联系厂家获取正确参数
-func (ac *AC) BindUserSearch(val string) error {
-	var req = &acReq{
-		uri:    ac.baseUrl + acBindInfoUser,
-		method: acGet,
-		Query:  map[string]string{"search": val},
+// BindUserSearch 查询用户和IP/MAC的绑定关系,val为用户名,IP或MAC(MAC需带分隔符,支持常见写法,发送前规范化为AC格式)
+// 依次尝试查询参数与请求体(POST+_method=GET)格式,均被拒绝或设备版本不支持时由 UserSearch 返回的用户绑定信息得出
+func (ac *AC) BindUserSearch(val string) ([]BindUser, error) {
+	if ip, err := acNormalizeIP(val); err == nil {
+		val = ip
+	} else if mac, ok := acSeparatedMAC(val); ok {
+		val = mac.String()
 	}
-	dataBytes, err := ac.send(req)
+	if ac.require(CapBindUser) != nil {
+		return ac.bindUserSearchFallback(val)
+	}
+	dataBytes, rejected, err := ac.sendAny(acRetryRejected,
+		&acReq{uri: ac.baseUrl + acBindInfoUser, method: acGet, Query: map[string]string{"search": val}},
+		&acReq{uri: ac.baseUrl + acBindInfoUser, method: acPost, Query: map[string]string{"_method": acGet}, Data: map[string]interface{}{"search": val}},
+	)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if rejected {
+		return ac.bindUserSearchFallback(val)
 	}
 	if len(dataBytes) == 0 {
-		return errors.New(acErrNoData)
+		return nil, errors.New(acErrNoData)
 	}
 	dataBytes = acFixJson(dataBytes)
-	var r []FluxPolicy
+	var r []BindUser
 	var resp = &acResp{Data: &r}
 	err = json.Unmarshal(dataBytes, resp)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if resp.Code != 0 {
-		return errors.New(resp.Message)
+		return nil, errors.New(resp.Message)
 	}
-	return nil
+	return r, nil
 }
 
 // BindUser 用户绑定结构体
@@ -1200,21 +1274,19 @@ type BindUser struct {
 }
 
 // BindUserAdd 增加用户的IP/MAC绑定
-// FIXME:单元测试报错(请求的接口数据格式不正确!),需联系厂家获取正确参数
+// 设备版本对应的格式在前,AC提示格式不正确时依次尝试单条绑定与列表(bindinfo)格式
 func (ac *AC) BindUserAdd(data BindUser) (string, error) {
-	var req = &acReq{
-		uri:    ac.baseUrl + acBindInfoUser,
-		method: acPost,
+	if err := ac.require(CapBindUser); err != nil {
+		return "", err
 	}
-	dataJson, err := json.Marshal(data)
-	if err != nil {
+	if err := data.normalize(); err != nil {
 		return "", err
 	}
-	err = json.Unmarshal(dataJson, &req.Data)
+	reqs, err := ac.bindUserReqs(data)
 	if err != nil {
 		return "", err
 	}
-	dataBytes, err := ac.send(req)
+	dataBytes, _, err := ac.sendAny(acRetryRejected, reqs...)
 	if err != nil {
 		return "", err
 	}
@@ -1233,15 +1305,15 @@ func (ac *AC) BindUserAdd(data BindUser) (string, error) {
 }
 
 // BindUserDel 删除用户和IP/MAC的绑定关系
-// FIXME:单元测试报错(请求的接口数据格式不正确!),需联系厂家获取正确参数
+// AC提示格式不正确时改为将addr放在查询参数中
 func (ac *AC) BindUserDel(addr string) (string, error) {
-	var req = &acReq{
-		uri:    ac.baseUrl + acBindInfoUser,
-		method: acPost,
-		Query:  map[string]string{"_method": "DELETE"},
-		Data:   map[string]interface{}{"addr": addr},
+	if err := ac.require(CapBindUser); err != nil {
+		return "", err
 	}
-	dataBytes, err := ac.send(req)
+	dataBytes, _, err := ac.sendAny(acRetryRejected,
+		&acReq{uri: ac.baseUrl + acBindInfoUser, method: acPost, Query: map[string]string{"_method": "DELETE"}, Data: map[string]interface{}{"addr": addr}},
+		&acReq{uri: ac.baseUrl + acBindInfoUser, method: acPost, Query: map[string]string{"_method": "DELETE", "addr": addr}},
+	)
 	if err != nil {
 		return "", err
 	}
@@ -1259,6 +1331,7 @@ func (ac *AC) BindUserDel(addr string) (string, error) {
 	return resp.Data.(string), nil
 }
 
+// BindIpMac IP/MAC绑定结构体,MAC支持常见写法,发送前会规范化为AC格式
 type BindIpMac struct {
 	Ip   string `json:"ip,omitempty"`   // IP
 	Mac  string `json:"mac,omitempty"`  // MAC
@@ -1268,6 +1341,9 @@ type BindIpMac struct {
 // BindIpmacSearch 查询IPMac绑定关系(支持按ip/mac进行搜索)
 // 如果没有查询到则会返回错误
 func (ac *AC) BindIpmacSearch(val string) (*BindIpMac, error) {
+	if err := ac.require(CapBindIpMac); err != nil {
+		return nil, err
+	}
 	var req = &acReq{
 		uri:    ac.baseUrl + acBindInfoIpMac,
 		method: acGet,
@@ -1303,7 +1379,13 @@ func (ac *AC) BindIpmacAdd(bind BindIpMac) error {
 		}
 	)
 	if bind.Ip == "" || bind.Mac == "" {
-		return errors.New(acErrArgCheck)
+		return acErrArg()
+	}
+	if err = ac.require(CapBindIpMac); err != nil {
+		return err
+	}
+	if err = bind.normalize(); err != nil {
+		return err
 	}
 	req.Data, err = acTransJsonMap(bind)
 	if err != nil {
@@ -1329,6 +1411,9 @@ func (ac *AC) BindIpmacAdd(bind BindIpMac) error {
 
 // BindIpmacDel 删除IP/MAC绑定信息
 func (ac *AC) BindIpmacDel(ip string) error {
+	if err := ac.require(CapBindIpMac); err != nil {
+		return err
+	}
 	var req = &acReq{
 		uri:    ac.baseUrl + acBindInfoIpMacOp,
 		method: acPost,
@@ -1455,20 +1540,28 @@ type OnlineUserUp struct {
 }
 
 // OnlineUserUp 上线在线用户(单点登录)
-// FIXME:单元测试报错(请求的接口数据格式不正确!),需联系厂家获取正确参数
+// AC提示格式不正确时依次尝试去掉空字段的请求体,以及将字段放在查询参数中
 func (ac *AC) OnlineUserUp(user OnlineUserUp) error {
-	var (
-		err error
-		req = &acReq{
-			uri:    ac.baseUrl + acOnlineUsers,
-			method: acPost,
-		}
-	)
-	req.Data, err = acTransJsonMap(user)
+	if err := ac.require(CapOnlineUserUp); err != nil {
+		return err
+	}
+	data, err := acTransJsonMap(user)
 	if err != nil {
 		return err
 	}
-	dataBytes, err := ac.send(req)
+	var (
+		compact = make(map[string]interface{})
+		query   = make(map[string]string)
+	)
+	for k, v := range data {
+		if !acIsZero(v) {
+			compact[k], query[k] = v, fmt.Sprint(v)
+		}
+	}
+	dataBytes, _, err := ac.sendAny(acRetryRejected, acAppendReq([]*acReq{
+		{uri: ac.baseUrl + acOnlineUsers, method: acPost, Data: data},
+		{uri: ac.baseUrl + acOnlineUsers, method: acPost, Data: compact},
+	}, &acReq{uri: ac.baseUrl + acOnlineUsers, method: acPost, Query: query})...)
 	if err != nil {
 		return err
 	}
@@ -1500,50 +1593,81 @@ type acResp struct {
 }
 
 func (ac *AC) send(req *acReq) ([]byte, error) {
+	r := &Request{
+		Target:   ac.target(),
+		Endpoint: strings.TrimPrefix(req.uri, ac.baseUrl),
+		Method:   req.method,
+		Query:    req.Query,
+		Data:     req.Data,
+		Context:  ac.context(),
+	}
+	if ac.bbc != nil {
+		if err := ac.bbc.route(r); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := ac.handler()(r)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return nil, errors.New("response nil")
+	}
+	return resp.Body, nil
+}
+
+// do 签名并发送请求,为拦截器链的最内层
+func (ac *AC) do(req *Request) (*Response, error) {
 	var (
 		dataBytes   []byte
 		err         error
+		uri         = ac.baseUrl + req.Endpoint
 		random, key = ac.setRandomKey()
 	)
 	if req.Query != nil && len(req.Query) > 0 {
-		if strings.Index(req.uri, "?") == -1 {
-			req.uri += "?"
+		if strings.Index(uri, "?") == -1 {
+			uri += "?"
 		} else {
-			req.uri += "&"
+			uri += "&"
 		}
-		var qlist []string
+		query := make(url.Values, len(req.Query))
 		for k, v := range req.Query {
-			qlist = append(qlist, fmt.Sprintf("%s=%s", k, v))
+			query.Set(k, v)
 		}
-		req.uri += strings.Join(qlist, "&")
+		uri += query.Encode()
 	}
 
-	if strings.ToUpper(req.method) == "GET" {
-		if strings.Index(req.uri, "?") == -1 {
-			req.uri += "?"
+	if strings.ToUpper(req.Method) == "GET" {
+		if strings.Index(uri, "?") == -1 {
+			uri += "?"
 		} else {
-			req.uri += "&"
+			uri += "&"
 		}
 		queryList := []string{
 			fmt.Sprintf("%s=%s", "random", random),
 			fmt.Sprintf("%s=%s", "md5", key),
 		}
-		req.uri += strings.Join(queryList, "&")
+		uri += strings.Join(queryList, "&")
 	}
 
-	if strings.ToUpper(req.method) == "POST" {
-		if req.Data == nil {
-			req.Data = make(map[string]interface{})
+	if strings.ToUpper(req.Method) == "POST" {
+		data := make(map[string]interface{}, len(req.Data)+2)
+		for k, v := range req.Data {
+			data[k] = v
 		}
-		req.Data["random"] = random
-		req.Data["md5"] = key
-		dataBytes, err = json.Marshal(req.Data)
+		data["random"] = random
+		data["md5"] = key
+		dataBytes, err = json.Marshal(data)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	httpReq, err := http.NewRequest(req.method, req.uri, bytes.NewBuffer(dataBytes))
+	ctx := req.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, uri, bytes.NewBuffer(dataBytes))
 	if err != nil {
 		return nil, err
 	}
@@ -1552,7 +1676,6 @@ func (ac *AC) send(req *acReq) ([]byte, error) {
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
 	client := &http.Client{Timeout: 20 * time.Second}
-	log.Println(req.uri)
 	resp, err := client.Do(httpReq)
 	if err != nil {
 		return nil, err
@@ -1566,7 +1689,7 @@ func (ac *AC) send(req *acReq) ([]byte, error) {
 	if len(body) == 0 {
 		return nil, errors.New("response nil")
 	}
-	return body, nil
+	return &Response{StatusCode: resp.StatusCode, Body: body}, nil
 }
 
 func (ac *AC) setRandomKey() (string, string) {
@@ -1581,6 +1704,11 @@ func (ac *AC) setRandomKey() (string, string) {
 	return rd, fmt.Sprintf("%x", md5Handler.Sum(nil))
 }
 
+// target 返回设备地址(ip+端口)
+func (ac *AC) target() string {
+	return strings.TrimSuffix(strings.TrimPrefix(ac.baseUrl, "http://"), "/v1/")
+}
+
 func acTransJsonMap(src interface{}) (map[string]interface{}, error) {
 	var r = make(map[string]interface{})
 	bingJ, err := json.Marshal(src)
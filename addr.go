@@ -0,0 +1,404 @@
+/**
+ * @Description: sangfor ac address value types
+ * @File:  addr
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// MAC 网卡地址,输出为AC使用的格式(e.g:ee-ee-ee-ee-ee-ee)
+type MAC [6]byte
+
+// ParseMAC 解析常见写法的MAC地址,支持冒号,横杠,点分(eeee.eeee.eeee)及无分隔符写法,不区分大小写
+func ParseMAC(s string) (MAC, error) {
+	var m MAC
+	raw := strings.NewReplacer(":", "", "-", "", ".", "", " ", "").Replace(strings.TrimSpace(s))
+	if len(raw) != 12 {
+		return m, acArgErrorf("invalid mac address %q", s)
+	}
+	b, err := hex.DecodeString(raw)
+	if err != nil {
+		return m, acArgErrorf("invalid mac address %q", s)
+	}
+	copy(m[:], b)
+	return m, nil
+}
+
+// String 按AC格式输出MAC地址
+func (m MAC) String() string {
+	return fmt.Sprintf("%02x-%02x-%02x-%02x-%02x-%02x", m[0], m[1], m[2], m[3], m[4], m[5])
+}
+
+// IsZero MAC地址是否为空
+func (m MAC) IsZero() bool {
+	return m == MAC{}
+}
+
+func (m MAC) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *MAC) UnmarshalText(text []byte) error {
+	r, err := ParseMAC(string(text))
+	if err != nil {
+		return err
+	}
+	*m = r
+	return nil
+}
+
+// IPRange IP段,单个IP时起止相同
+type IPRange struct {
+	Start net.IP
+	End   net.IP
+}
+
+// ParseIPRange 解析IP段,支持单个IP(1.1.1.1),起止写法(1.1.1.1-1.1.1.10,1.1.1.1~1.1.1.10)及CIDR(1.1.1.0/24)
+func ParseIPRange(s string) (IPRange, error) {
+	s = strings.TrimSpace(s)
+	if _, ipNet, err := net.ParseCIDR(s); err == nil {
+		start := ipNet.IP
+		end := make(net.IP, len(start))
+		for i := range start {
+			end[i] = start[i] | ^ipNet.Mask[i]
+		}
+		return acNewIPRange(start, end, s)
+	}
+	sep := strings.IndexAny(s, "-~")
+	if sep < 0 {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return IPRange{}, acArgErrorf("invalid ip range %q", s)
+		}
+		return acNewIPRange(ip, ip, s)
+	}
+	start, end := net.ParseIP(strings.TrimSpace(s[:sep])), net.ParseIP(strings.TrimSpace(s[sep+1:]))
+	if start == nil || end == nil {
+		return IPRange{}, acArgErrorf("invalid ip range %q", s)
+	}
+	return acNewIPRange(start, end, s)
+}
+
+func acNewIPRange(start, end net.IP, src string) (IPRange, error) {
+	if (start.To4() == nil) != (end.To4() == nil) || bytes.Compare(start.To16(), end.To16()) > 0 {
+		return IPRange{}, acArgErrorf("invalid ip range %q", src)
+	}
+	if v4 := start.To4(); v4 != nil {
+		start, end = v4, end.To4()
+	}
+	return IPRange{Start: start, End: end}, nil
+}
+
+// String 按AC格式输出IP段(e.g:192.168.1.1-192.168.1.2),单个IP时只输出该IP
+func (r IPRange) String() string {
+	if r.Start.Equal(r.End) {
+		return r.Start.String()
+	}
+	return r.Start.String() + "-" + r.End.String()
+}
+
+// Contains IP是否在该IP段内
+func (r IPRange) Contains(ip net.IP) bool {
+	if ip == nil || (ip.To4() == nil) != (r.Start.To4() == nil) {
+		return false
+	}
+	ip = ip.To16()
+	return bytes.Compare(ip, r.Start.To16()) >= 0 && bytes.Compare(ip, r.End.To16()) <= 0
+}
+
+// Validate 校验IP段是否合法
+func (r IPRange) Validate() error {
+	if r.Start == nil || r.End == nil {
+		return acArgErrorf("invalid ip range: start and end are required")
+	}
+	_, err := acNewIPRange(r.Start, r.End, r.String())
+	return err
+}
+
+// MarshalJSON 按用户搜索接口格式输出(e.g:{"start":"1.1.1.1","end":"1.1.1.10"})
+func (r IPRange) MarshalJSON() ([]byte, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]string{"start": r.Start.String(), "end": r.End.String()})
+}
+
+func (r *IPRange) UnmarshalJSON(data []byte) error {
+	var str string
+	if json.Unmarshal(data, &str) == nil {
+		v, err := ParseIPRange(str)
+		if err != nil {
+			return err
+		}
+		*r = v
+		return nil
+	}
+	var obj struct {
+		Start string `json:"start"`
+		End   string `json:"end"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	v, err := ParseIPRange(obj.Start + "-" + obj.End)
+	if err != nil {
+		return err
+	}
+	*r = v
+	return nil
+}
+
+// IPMac IP+MAC组合地址(e.g:192.168.1.1+ff-ff-ff-ff-ff-ff)
+type IPMac struct {
+	IP  net.IP
+	MAC MAC
+}
+
+// ParseIPMac 解析IP+MAC组合地址,分隔符支持"+"和空格
+func ParseIPMac(s string) (IPMac, error) {
+	s = strings.TrimSpace(s)
+	sep := strings.IndexAny(s, "+ ")
+	if sep < 0 {
+		return IPMac{}, acArgErrorf("invalid ip+mac address %q", s)
+	}
+	ip := net.ParseIP(strings.TrimSpace(s[:sep]))
+	if ip == nil {
+		return IPMac{}, acArgErrorf("invalid ip+mac address %q", s)
+	}
+	mac, err := ParseMAC(s[sep+1:])
+	if err != nil {
+		return IPMac{}, acArgErrorf("invalid ip+mac address %q", s)
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	return IPMac{IP: ip, MAC: mac}, nil
+}
+
+// String 按AC格式输出IP+MAC地址
+func (a IPMac) String() string {
+	return a.IP.String() + "+" + a.MAC.String()
+}
+
+func (a IPMac) MarshalText() ([]byte, error) {
+	if a.IP == nil {
+		return nil, acArgErrorf("invalid ip+mac address: ip is required")
+	}
+	return []byte(a.String()), nil
+}
+
+func (a *IPMac) UnmarshalText(text []byte) error {
+	r, err := ParseIPMac(string(text))
+	if err != nil {
+		return err
+	}
+	*a = r
+	return nil
+}
+
+// acNormalizeIP 校验并规范化单个IP
+func acNormalizeIP(s string) (string, error) {
+	ip := net.ParseIP(strings.TrimSpace(s))
+	if ip == nil {
+		return "", acArgErrorf("invalid ip address %q", s)
+	}
+	return ip.String(), nil
+}
+
+// acNormalizeMAC 校验并规范化MAC地址为AC格式
+func acNormalizeMAC(s string) (string, error) {
+	m, err := ParseMAC(s)
+	if err != nil {
+		return "", err
+	}
+	return m.String(), nil
+}
+
+// acSeparatedMAC 带分隔符(:,-,.)的MAC地址,不带分隔符的12位字符(可能是工号等用户名)不视为MAC
+func acSeparatedMAC(s string) (MAC, bool) {
+	if !strings.ContainsAny(s, ":-.") {
+		return MAC{}, false
+	}
+	m, err := ParseMAC(s)
+	return m, err == nil
+}
+
+// acNormalizeIPOrMAC 校验并规范化登录限制地址(单个IP,IP段或MAC)
+func acNormalizeIPOrMAC(s string) (string, error) {
+	if r, err := ParseIPRange(s); err == nil {
+		return r.String(), nil
+	}
+	m, err := ParseMAC(s)
+	if err != nil {
+		return "", acArgErrorf("invalid ip or mac address %q", s)
+	}
+	return m.String(), nil
+}
+
+// acNormalizeBindAddr 按绑定类型(ip/mac/ipmac)校验并规范化绑定地址
+func acNormalizeBindAddr(addrType, addr string) (string, error) {
+	switch addrType {
+	case "ip":
+		return acNormalizeIP(addr)
+	case "mac":
+		return acNormalizeMAC(addr)
+	case "ipmac":
+		a, err := ParseIPMac(addr)
+		if err != nil {
+			return "", err
+		}
+		return a.String(), nil
+	case "":
+		return addr, nil
+	}
+	return "", acArgErrorf("invalid bind addr_type %q", addrType)
+}
+
+// normalize 校验并规范化用户的登录限制地址及IP/MAC绑定
+func (u *UserAdd) normalize() error {
+	if len(u.LimitIpmac) > 0 {
+		limit := make([]string, len(u.LimitIpmac))
+		for i, v := range u.LimitIpmac {
+			addr, err := acNormalizeIPOrMAC(v)
+			if err != nil {
+				return err
+			}
+			limit[i] = addr
+		}
+		u.LimitIpmac = limit
+	}
+	if len(u.BindCfg) > 0 {
+		binds := make([]UserBindCfg, len(u.BindCfg))
+		copy(binds, u.BindCfg)
+		for i := range binds {
+			var err error
+			if binds[i].Ip == "" && binds[i].Mac == "" {
+				return acArgErrorf("invalid bind_cfg: ip or mac is required")
+			}
+			if binds[i].Ip != "" {
+				if binds[i].Ip, err = acNormalizeIP(binds[i].Ip); err != nil {
+					return err
+				}
+			}
+			if binds[i].Mac != "" {
+				if binds[i].Mac, err = acNormalizeMAC(binds[i].Mac); err != nil {
+					return err
+				}
+			}
+		}
+		u.BindCfg = binds
+	}
+	return nil
+}
+
+// normalize 校验并规范化搜索值,ip搜索时支持传入 IPRange 或字符串IP段
+func (s *UserSearch) normalize() error {
+	switch s.SearchType {
+	case "user":
+		if _, ok := s.SearchValue.(string); !ok {
+			return acArgErrorf("invalid search_value for search_type user: %T", s.SearchValue)
+		}
+	case "mac":
+		var (
+			mac MAC
+			err error
+		)
+		switch v := s.SearchValue.(type) {
+		case MAC:
+			mac = v
+		case string:
+			mac, err = ParseMAC(v)
+		default:
+			err = acArgErrorf("invalid search_value for search_type mac: %T", s.SearchValue)
+		}
+		if err != nil {
+			return err
+		}
+		s.SearchValue = mac.String()
+	case "ip":
+		var (
+			r   IPRange
+			err error
+		)
+		switch v := s.SearchValue.(type) {
+		case IPRange:
+			r, err = v, v.Validate()
+		case *IPRange:
+			if v == nil {
+				return acArgErrorf("invalid search_value for search_type ip: nil")
+			}
+			r, err = *v, v.Validate()
+		case string:
+			r, err = ParseIPRange(v)
+		case map[string]string:
+			r, err = ParseIPRange(v["start"] + "-" + v["end"])
+		case map[string]interface{}:
+			start, _ := v["start"].(string)
+			end, _ := v["end"].(string)
+			r, err = ParseIPRange(start + "-" + end)
+		default:
+			err = acArgErrorf("invalid search_value for search_type ip: %T", s.SearchValue)
+		}
+		if err != nil {
+			return err
+		}
+		s.SearchValue = r
+	default:
+		return acArgErrorf("invalid search_type %q", s.SearchType)
+	}
+	return nil
+}
+
+// normalize 按绑定类型校验并规范化绑定地址
+func (b *BindUser) normalize() error {
+	addr, err := acNormalizeBindAddr(b.AddrType, b.Addr)
+	if err != nil {
+		return err
+	}
+	b.Addr = addr
+	return nil
+}
+
+// normalize 校验并规范化IP/MAC绑定
+func (b *BindIpMac) normalize() error {
+	var err error
+	if b.Ip, err = acNormalizeIP(b.Ip); err != nil {
+		return err
+	}
+	b.Mac, err = acNormalizeMAC(b.Mac)
+	return err
+}
+
+// ArgError 本地参数校验错误(参数检查失败或IP/MAC,搜索条件等格式错误),用于与设备返回的错误区分
+type ArgError struct {
+	Msg string
+}
+
+func (e *ArgError) Error() string {
+	return e.Msg
+}
+
+func acArgErrorf(format string, a ...interface{}) error {
+	return &ArgError{Msg: fmt.Sprintf(format, a...)}
+}
+
+// acErrArg 参数检查失败
+func acErrArg() error {
+	return &ArgError{Msg: acErrArgCheck}
+}
+
+// IsArgError 是否为本地参数校验错误 *ArgError
+func IsArgError(err error) bool {
+	var ae *ArgError
+	return errors.As(err, &ae)
+}
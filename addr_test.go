@@ -0,0 +1,110 @@
+package sangfor
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestParseMAC(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+		err  bool
+	}{
+		{"ee-ee-ee-ee-ee-ee", "ee-ee-ee-ee-ee-ee", false},
+		{"AA:BB:CC:DD:EE:FF", "aa-bb-cc-dd-ee-ff", false},
+		{"aabb.ccdd.eeff", "aa-bb-cc-dd-ee-ff", false},
+		{" aabbccddeeff ", "aa-bb-cc-dd-ee-ff", false},
+		{"aa:bb:cc:dd:ee", "", true},
+		{"gg:bb:cc:dd:ee:ff", "", true},
+		{"", "", true},
+	}
+	for _, c := range cases {
+		m, err := ParseMAC(c.in)
+		if (err != nil) != c.err {
+			t.Fatalf("ParseMAC(%q) err = %v, want error %v", c.in, err, c.err)
+		}
+		if !c.err && m.String() != c.want {
+			t.Fatalf("ParseMAC(%q) = %s, want %s", c.in, m, c.want)
+		}
+	}
+}
+
+func TestParseIPRange(t *testing.T) {
+	cases := []struct {
+		in       string
+		want     string
+		contains string
+		err      bool
+	}{
+		{"192.168.1.1", "192.168.1.1", "192.168.1.1", false},
+		{"192.168.1.1-192.168.1.10", "192.168.1.1-192.168.1.10", "192.168.1.5", false},
+		{"192.168.1.1~192.168.1.10", "192.168.1.1-192.168.1.10", "192.168.1.10", false},
+		{"10.0.0.0/30", "10.0.0.0-10.0.0.3", "10.0.0.2", false},
+		{"192.168.1.10-192.168.1.1", "", "", true},
+		{"192.168.1.1-::1", "", "", true},
+		{"host", "", "", true},
+	}
+	for _, c := range cases {
+		r, err := ParseIPRange(c.in)
+		if (err != nil) != c.err {
+			t.Fatalf("ParseIPRange(%q) err = %v, want error %v", c.in, err, c.err)
+		}
+		if c.err {
+			continue
+		}
+		if r.String() != c.want {
+			t.Fatalf("ParseIPRange(%q) = %s, want %s", c.in, r, c.want)
+		}
+		if !r.Contains(net.ParseIP(c.contains)) {
+			t.Fatalf("%s should contain %s", r, c.contains)
+		}
+	}
+}
+
+func TestNormalizeBindAddr(t *testing.T) {
+	cases := []struct {
+		addrType, addr string
+		want           string
+		err            bool
+	}{
+		{"ip", " 192.168.1.1 ", "192.168.1.1", false},
+		{"mac", "AA:BB:CC:DD:EE:FF", "aa-bb-cc-dd-ee-ff", false},
+		{"ipmac", "192.168.1.1 AABB.CCDD.EEFF", "192.168.1.1+aa-bb-cc-dd-ee-ff", false},
+		{"", "anything", "anything", false},
+		{"ip", "192.168.1.300", "", true},
+		{"ipmac", "192.168.1.1", "", true},
+		{"port", "80", "", true},
+	}
+	for _, c := range cases {
+		got, err := acNormalizeBindAddr(c.addrType, c.addr)
+		if (err != nil) != c.err {
+			t.Fatalf("acNormalizeBindAddr(%q, %q) err = %v, want error %v", c.addrType, c.addr, err, c.err)
+		}
+		if got != c.want {
+			t.Fatalf("acNormalizeBindAddr(%q, %q) = %q, want %q", c.addrType, c.addr, got, c.want)
+		}
+	}
+}
+
+func TestIsArgError(t *testing.T) {
+	_, macErr := ParseMAC("bad")
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{macErr, true},
+		{acErrArg(), true},
+		{fmt.Errorf("wrapped: %w", macErr), true},
+		{errors.New("invalid session"), false},
+		{errors.New(acErrArgCheck), false},
+		{nil, false},
+	}
+	for _, c := range cases {
+		if got := IsArgError(c.err); got != c.want {
+			t.Fatalf("IsArgError(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
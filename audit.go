@@ -0,0 +1,237 @@
+/**
+ * @Description: audit trail of mutating calls
+ * @File:  audit
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditRecord 一次变更操作的审计记录
+type AuditRecord struct {
+	Time     time.Time              `json:"time"`
+	Operator string                 `json:"operator"` // 操作人,来自 WithOperator
+	Target   string                 `json:"target"`   // 设备地址
+	Method   string                 `json:"method"`   // POST/PUT/DELETE
+	Endpoint string                 `json:"endpoint"` // 接口路径,如 user,group/netpolicy
+	Payload  map[string]interface{} `json:"payload,omitempty"`
+	Before   interface{}            `json:"before,omitempty"` // 变更前状态(开启 AuditState 且可获取时)
+	After    interface{}            `json:"after,omitempty"`  // 变更后状态
+	Code     int                    `json:"code"`             // AC返回码
+	Result   string                 `json:"result,omitempty"` // AC返回信息
+	Error    string                 `json:"error,omitempty"`  // 请求失败原因
+	Duration string                 `json:"duration"`
+}
+
+// AuditSink 审计记录输出
+type AuditSink interface {
+	WriteAudit(rec *AuditRecord) error
+}
+
+// AuditSinkFunc 函数形式的 AuditSink
+type AuditSinkFunc func(rec *AuditRecord) error
+
+func (f AuditSinkFunc) WriteAudit(rec *AuditRecord) error {
+	return f(rec)
+}
+
+// FileAuditSink 以JSON行追加写入审计文件
+type FileAuditSink struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+// NewFileAuditSink 以只追加方式打开审计文件
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &FileAuditSink{path: path, f: f}, nil
+}
+
+// Reopen 重新打开审计文件,用于外部工具(如logrotate)移走文件后写入新文件
+func (s *FileAuditSink) Reopen() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.f
+	s.f = f
+	return old.Close()
+}
+
+func (s *FileAuditSink) WriteAudit(rec *AuditRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err = s.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *FileAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
+
+type acOperatorKey struct{}
+
+// WithOperator 在context中记录操作人
+func WithOperator(ctx context.Context, operator string) context.Context {
+	return context.WithValue(ctx, acOperatorKey{}, operator)
+}
+
+// OperatorFromContext 获取context中的操作人
+func OperatorFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	op, _ := ctx.Value(acOperatorKey{}).(string)
+	return op
+}
+
+// WithContext 返回使用ctx发送请求的AC副本,ctx同时用于审计记录的操作人
+func (ac *AC) WithContext(ctx context.Context) *AC {
+	c := *ac
+	c.ctx = ctx
+	return &c
+}
+
+func (ac *AC) context() context.Context {
+	if ac.ctx == nil {
+		return context.Background()
+	}
+	return ac.ctx
+}
+
+// auditState 获取变更对象的当前状态,仅支持可低成本查询的接口,调用方需使用 acWithoutCache 读取设备当前状态
+func (ac *AC) auditState(endpoint string, data map[string]interface{}) interface{} {
+	var (
+		v   interface{}
+		err error
+	)
+	switch endpoint {
+	case acUser:
+		name, ok := data["name"].(string)
+		if !ok {
+			return nil
+		}
+		v, err = ac.UserGet(name)
+	case acUserNetPolicy:
+		user, ok := data["user"].(string)
+		if !ok {
+			return nil
+		}
+		v, err = ac.UserNetPolicyGet(user)
+	case acUserFluxPolicy:
+		user, ok := data["user"].(string)
+		if !ok {
+			return nil
+		}
+		v, err = ac.UserFluxPolicyGet(user)
+	case acGroupNetPolicy:
+		group, ok := data["group"].(string)
+		if !ok {
+			return nil
+		}
+		v, err = ac.GroupNetPolicyGet(group)
+	case acBindInfoIpMacOp:
+		ip, ok := data["ip"].(string)
+		if !ok {
+			return nil
+		}
+		v, err = ac.BindIpmacSearch(ip)
+	default:
+		return nil
+	}
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+// audit 审计变更请求的拦截器,位于拦截器链最内层,未设置 Audit 或非变更请求时直接发送
+// 审计记录写入失败时,若设置了 AuditStrict 则返回该错误(此时变更可能已生效)
+func (ac *AC) audit(req *Request, next Handler) (*Response, error) {
+	method := req.Operation()
+	if ac.Audit == nil || !req.Mutating() {
+		return next(req)
+	}
+	var (
+		start = time.Now()
+		rec   = &AuditRecord{
+			Time:     start,
+			Operator: OperatorFromContext(req.Context),
+			Target:   ac.target(),
+			Method:   method,
+			Endpoint: req.Endpoint,
+			Payload:  acSanitize(req.Data),
+		}
+	)
+	if ac.AuditState && req.Data != nil {
+		rec.Before = ac.WithContext(acWithoutCache(req.Context)).auditState(req.Endpoint, req.Data)
+	}
+	resp, err := next(req)
+	rec.Duration = time.Since(start).String()
+	if err != nil {
+		rec.Error = err.Error()
+	} else {
+		var r acResp
+		if json.Unmarshal(resp.Body, &r) == nil {
+			rec.Code, rec.Result = r.Code, r.Message
+			if s, ok := r.Data.(string); ok && rec.Result == "" {
+				rec.Result = s
+			}
+		}
+		if ac.AuditState && req.Data != nil {
+			rec.After = ac.WithContext(acWithoutCache(req.Context)).auditState(req.Endpoint, req.Data)
+		}
+	}
+	if werr := ac.Audit.WriteAudit(rec); werr != nil {
+		log.Println("audit:", werr)
+		if ac.AuditStrict && err == nil {
+			return nil, werr
+		}
+	}
+	return resp, err
+}
+
+// acSanitize 复制请求数据并去除签名及密码字段
+func acSanitize(data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		return nil
+	}
+	r := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		m, isMap := v.(map[string]interface{})
+		switch lk := strings.ToLower(k); {
+		case lk == "random" || lk == "md5":
+			continue
+		case isMap:
+			r[k] = acSanitize(m)
+		case strings.Contains(lk, "pass") || strings.Contains(lk, "secret"):
+			r[k] = "******"
+		default:
+			r[k] = v
+		}
+	}
+	return r
+}
@@ -0,0 +1,118 @@
+package sangfor
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestFileAuditSink 追加写入JSON行,轮转后 Reopen 写入新文件,关闭后写入失败
+func TestFileAuditSink(t *testing.T) {
+	var (
+		path    = filepath.Join(t.TempDir(), "audit.jsonl")
+		rotated = path + ".1"
+	)
+	s, err := NewFileAuditSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.WriteAudit(&AuditRecord{Endpoint: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.WriteAudit(&AuditRecord{Endpoint: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.WriteAudit(&AuditRecord{Endpoint: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.WriteAudit(&AuditRecord{Endpoint: "d"}); err == nil {
+		t.Fatal("want error writing to a closed sink")
+	}
+	for file, want := range map[string][]string{rotated: {"a", "b"}, path: {"c"}} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != len(want) {
+			t.Fatalf("%s: %d lines, want %d", file, len(lines), len(want))
+		}
+		for i, line := range lines {
+			var rec AuditRecord
+			if err := json.Unmarshal([]byte(line), &rec); err != nil || rec.Endpoint != want[i] {
+				t.Fatalf("%s line %d = %s (%v), want endpoint %s", file, i, line, err, want[i])
+			}
+		}
+	}
+}
+
+// TestAuditOperator 操作人来自 WithContext 传入的context,副本不影响原AC,查询请求不审计
+func TestAuditOperator(t *testing.T) {
+	ac, _ := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		return "ok", nil
+	})
+	var recs []*AuditRecord
+	ac.Audit = AuditSinkFunc(func(rec *AuditRecord) error {
+		recs = append(recs, rec)
+		return nil
+	})
+	if _, err := ac.WithContext(WithOperator(context.Background(), "alice")).UserDel("x"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ac.UserDel("y"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ac.GetVersion(); err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("%d audit records, want 2", len(recs))
+	}
+	if r := recs[0]; r.Operator != "alice" || r.Endpoint != acUser || r.Method != "DELETE" || r.Payload["name"] != "x" || r.Result != "ok" {
+		t.Fatalf("record = %+v", r)
+	}
+	if recs[1].Operator != "" {
+		t.Fatalf("operator = %q, the original AC must not carry the copy's context", recs[1].Operator)
+	}
+	if OperatorFromContext(nil) != "" {
+		t.Fatal("nil context has no operator")
+	}
+}
+
+// TestWithContextCancel 请求使用副本的context,取消后不再发送
+func TestWithContextCancel(t *testing.T) {
+	ac, d := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		return "AC13.0.15.097", nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ac.WithContext(ctx).GetVersion(); err == nil {
+		t.Fatal("want error for canceled context")
+	}
+	if _, err := ac.GetVersion(); err != nil || len(d.calls) != 1 {
+		t.Fatalf("err = %v, calls = %v, want only the original AC to reach the device", err, d.calls)
+	}
+}
+
+func TestAuditSanitize(t *testing.T) {
+	got := acSanitize(map[string]interface{}{"name": "a", "random": "1", "md5": "2", "password": "p",
+		"data": map[string]interface{}{"Password": "p", "desc": "d"}})
+	if len(got) != 3 || got["password"] != "******" || got["data"].(map[string]interface{})["Password"] != "******" ||
+		got["data"].(map[string]interface{})["desc"] != "d" {
+		t.Fatalf("sanitized = %v", got)
+	}
+}
@@ -0,0 +1,98 @@
+/**
+ * @Description: bbc central management mode
+ * @File:  bbc
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupported 设备(或BBC中心端)不支持该接口
+var ErrUnsupported = errors.New("operation not supported")
+
+// acBBCEndpoints BBC中心端支持的接口(用户,组,策略)
+var acBBCEndpoints = map[string]bool{
+	acUser:           true,
+	acUserNetPolicy:  true,
+	acUserFluxPolicy: true,
+	acGroup:          true,
+	acGroupNetPolicy: true,
+	acNetPolicy:      true,
+	acFluxPolicy:     true,
+}
+
+type acBBC struct {
+	param  string // 分支设备参数名,需由调用方按BBC接口文档设置
+	branch string // 为空时操作中心端本身
+}
+
+// NewBBC 创建BBC中心端操作对象,target为ip+端口,secret为BBC上配置的开放接口密钥
+// 只支持用户,组,策略接口,其他接口返回 ErrUnsupported,请求与返回结构与AC相同
+func NewBBC(target, secret string) *AC {
+	ac := NewAC(target, secret)
+	ac.bbc = &acBBC{}
+	return ac
+}
+
+// IsBBC 是否为BBC中心端模式
+func (ac *AC) IsBBC() bool {
+	return ac.bbc != nil
+}
+
+// SetBBCBranchParam 设置指定分支设备的查询参数名,以BBC版本的接口文档为准,调用 Branch 前必须设置
+func (ac *AC) SetBBCBranchParam(param string) {
+	if ac.bbc != nil {
+		b := *ac.bbc
+		b.param = param
+		ac.bbc = &b
+	}
+}
+
+// Branch 返回通过BBC中心端操作分支设备的副本,非BBC模式时返回错误
+func (ac *AC) Branch(id string) (*AC, error) {
+	if ac.bbc == nil {
+		return nil, fmt.Errorf("%w: branch requires BBC mode", ErrUnsupported)
+	}
+	if id == "" {
+		return nil, acErrArg()
+	}
+	if ac.bbc.param == "" {
+		return nil, errors.New("BBC branch parameter is not set, see SetBBCBranchParam")
+	}
+	c := *ac
+	b := *ac.bbc
+	b.branch = id
+	c.bbc = &b
+	c.firmware = &acFirmware{} // 分支设备的固件版本及时钟可能不同
+	c.clock = &acClock{}
+	return &c, nil
+}
+
+// BranchID 返回当前操作的分支设备,为空表示中心端本身
+func (ac *AC) BranchID() string {
+	if ac.bbc == nil {
+		return ""
+	}
+	return ac.bbc.branch
+}
+
+// route 校验BBC支持的接口,并附加分支设备参数
+func (b *acBBC) route(req *Request) error {
+	if !acBBCEndpoints[req.Endpoint] {
+		return fmt.Errorf("%w: %s on BBC", ErrUnsupported, req.Endpoint)
+	}
+	if b.branch == "" {
+		return nil
+	}
+	query := make(map[string]string, len(req.Query)+1)
+	for k, v := range req.Query {
+		query[k] = v
+	}
+	query[b.param] = b.branch
+	req.Query = query
+	return nil
+}
@@ -0,0 +1,80 @@
+package sangfor
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBBCBranch(t *testing.T) {
+	var query string
+	dev, _ := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		query = r.URL.RawQuery
+		return "ok", nil
+	})
+	bbc := NewBBC(dev.target(), "secret")
+	if _, err := bbc.Branch("b1"); err == nil || IsArgError(err) {
+		t.Fatalf("err = %v, want error when branch parameter is not set", err)
+	}
+	bbc.SetBBCBranchParam("device_id")
+	if _, err := bbc.Branch(""); !IsArgError(err) {
+		t.Fatalf("err = %v, want ArgError for empty branch", err)
+	}
+	b1, err := bbc.Branch("b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b1.clock == bbc.clock || b1.firmware == bbc.firmware || b1.BranchID() != "b1" || bbc.BranchID() != "" {
+		t.Fatal("branch shares state with the center")
+	}
+	if _, err = b1.UserDel("alice"); err != nil {
+		t.Fatalf("UserDel on BBC branch: %v", err)
+	}
+	if !strings.Contains(query, "device_id=b1") || !strings.Contains(query, "_method=DELETE") {
+		t.Fatalf("query = %q, want branch parameter", query)
+	}
+	if _, err = bbc.UserDel("alice"); err != nil || strings.Contains(query, "device_id") {
+		t.Fatalf("err = %v, query = %q, want no branch parameter on the center", err, query)
+	}
+
+	if _, err := NewAC(dev.target(), "secret").Branch("b1"); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("err = %v, want ErrUnsupported outside BBC mode", err)
+	}
+}
+
+// TestBBCUnsupported 不在BBC接口列表中的接口不发送请求,固件版本视为未知
+func TestBBCUnsupported(t *testing.T) {
+	dev, d := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		return "AC13.0.15.097", nil
+	})
+	bbc := NewBBC(dev.target(), "secret")
+	bbc.SetBBCBranchParam("device_id")
+	b1, _ := bbc.Branch("b1")
+	for _, ac := range []*AC{bbc, b1} {
+		if _, err := ac.GetVersion(); !errors.Is(err, ErrUnsupported) {
+			t.Fatalf("GetVersion err = %v, want ErrUnsupported", err)
+		}
+		if _, err := ac.GetOnlineUserCount(); !errors.Is(err, ErrUnsupported) {
+			t.Fatalf("GetOnlineUserCount err = %v, want ErrUnsupported", err)
+		}
+		if err := ac.OnlineUserKick("10.0.0.1"); !errors.Is(err, ErrUnsupported) {
+			t.Fatalf("OnlineUserKick err = %v, want ErrUnsupported", err)
+		}
+		if !ac.Supports(CapBindUser) {
+			t.Fatal("unknown firmware should not restrict capabilities")
+		}
+	}
+	if d.calls != nil {
+		t.Fatalf("calls = %v, want none", d.calls)
+	}
+
+	// 手动指定的固件版本仍然生效
+	if err := b1.SetFirmware("AC13.0.15.097"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := b1.Firmware(); err != nil || !reflect.DeepEqual(v.Parts, []int{13, 0, 15, 97}) {
+		t.Fatalf("firmware = %v, %v", v, err)
+	}
+}
@@ -0,0 +1,190 @@
+/**
+ * @Description: read-through ttl cache for read-mostly endpoints
+ * @File:  cache
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// acCacheInvalidate 变更接口及其影响的可缓存接口
+var acCacheInvalidate = map[string][]string{
+	acUser:            {acUserNetPolicy, acUserFluxPolicy, acNetPolicy, acFluxPolicy},
+	acUserNetPolicy:   {acUserNetPolicy, acNetPolicy},
+	acUserFluxPolicy:  {acUserFluxPolicy, acFluxPolicy},
+	acGroup:           {acGroupNetPolicy, acUserNetPolicy, acNetPolicy, acFluxPolicy},
+	acGroupNetPolicy:  {acGroupNetPolicy, acUserNetPolicy, acNetPolicy},
+	acBindInfoUser:    {acBindInfoUser},
+	acBindInfoIpMacOp: {acBindInfoIpMac},
+}
+
+// DefaultCacheEndpoints 默认缓存的接口(策略列表,用户/组策略)
+var DefaultCacheEndpoints = []string{acNetPolicy, acFluxPolicy, acUserNetPolicy, acUserFluxPolicy, acGroupNetPolicy}
+
+// Cache 读请求的TTL缓存,相同的并发请求只发送一次,相关变更操作后自动失效
+// 通过 AC.Use(cache.Interceptor()) 启用
+type Cache struct {
+	ttl       time.Duration
+	endpoints map[string]bool
+
+	mu      sync.Mutex
+	entries map[string]*acCacheEntry
+	flights map[string]*acFlight
+	gens    map[string]uint64 // 各接口的失效次数,用于丢弃失效前发出的请求结果
+}
+
+type acCacheEntry struct {
+	endpoint string
+	resp     *Response
+	expire   time.Time
+}
+
+type acFlight struct {
+	wg   sync.WaitGroup
+	resp *Response
+	err  error
+}
+
+// NewCache 创建缓存,endpoints为需要缓存的接口,为空时使用 DefaultCacheEndpoints
+func NewCache(ttl time.Duration, endpoints ...string) *Cache {
+	if len(endpoints) == 0 {
+		endpoints = DefaultCacheEndpoints
+	}
+	c := &Cache{
+		ttl:       ttl,
+		endpoints: make(map[string]bool),
+		entries:   make(map[string]*acCacheEntry),
+		flights:   make(map[string]*acFlight),
+		gens:      make(map[string]uint64),
+	}
+	for _, e := range endpoints {
+		c.endpoints[e] = true
+	}
+	return c
+}
+
+// Interceptor 返回缓存拦截器
+func (c *Cache) Interceptor() Interceptor {
+	return func(req *Request, next Handler) (*Response, error) {
+		if req.Mutating() {
+			// 变更前后均失效,变更期间发出的读请求结果不会被缓存
+			c.Invalidate(acCacheInvalidate[req.Endpoint]...)
+			resp, err := next(req)
+			c.Invalidate(acCacheInvalidate[req.Endpoint]...)
+			return resp, err
+		}
+		if !c.endpoints[req.Endpoint] || acSkipCache(req.Context) {
+			return next(req)
+		}
+		key := acCacheKey(req)
+		c.mu.Lock()
+		if e, ok := c.entries[key]; ok && time.Now().Before(e.expire) {
+			c.mu.Unlock()
+			return e.resp, nil
+		}
+		if f, ok := c.flights[key]; ok {
+			c.mu.Unlock()
+			f.wg.Wait()
+			return f.resp, f.err
+		}
+		f := &acFlight{}
+		f.wg.Add(1)
+		c.flights[key] = f
+		gen := c.gens[req.Endpoint]
+		c.mu.Unlock()
+
+		f.resp, f.err = next(req)
+
+		c.mu.Lock()
+		delete(c.flights, key)
+		if f.err == nil && c.gens[req.Endpoint] == gen && acResultOK(f.resp) {
+			c.entries[key] = &acCacheEntry{endpoint: req.Endpoint, resp: f.resp, expire: time.Now().Add(c.ttl)}
+		}
+		c.mu.Unlock()
+		f.wg.Done()
+		return f.resp, f.err
+	}
+}
+
+// acNoCacheKey context中标记请求跳过缓存
+type acNoCacheKey struct{}
+
+// acWithoutCache 返回跳过缓存的context,用于需要读取设备当前状态的请求(如审计变更前后的状态)
+func acWithoutCache(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, acNoCacheKey{}, true)
+}
+
+func acSkipCache(ctx context.Context) bool {
+	return ctx != nil && ctx.Value(acNoCacheKey{}) != nil
+}
+
+// Invalidate 使指定接口的缓存失效
+func (c *Cache) Invalidate(endpoints ...string) {
+	if len(endpoints) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	drop := make(map[string]bool, len(endpoints))
+	for _, e := range endpoints {
+		drop[e] = true
+		c.gens[e]++
+	}
+	for k, e := range c.entries {
+		if drop[e.endpoint] {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// Purge 清空所有缓存
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for e := range c.endpoints {
+		c.gens[e]++
+	}
+	c.entries = make(map[string]*acCacheEntry)
+}
+
+// acCacheKey 由设备,接口,操作,查询参数和请求数据组成缓存key
+func acCacheKey(req *Request) string {
+	var (
+		b    strings.Builder
+		keys = make([]string, 0, len(req.Query))
+	)
+	b.WriteString(req.Target + "|" + req.Endpoint + "|" + req.Operation() + "|")
+	for k := range req.Query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteString(k + "=" + req.Query[k] + "&")
+	}
+	if len(req.Data) > 0 {
+		data, _ := json.Marshal(req.Data)
+		b.WriteString("|")
+		b.Write(data)
+	}
+	return b.String()
+}
+
+// acResultOK 响应是否为成功结果,只缓存成功结果
+func acResultOK(resp *Response) bool {
+	if resp == nil {
+		return false
+	}
+	var r acResp
+	return json.Unmarshal(resp.Body, &r) == nil && r.Code == 0
+}
@@ -0,0 +1,89 @@
+package sangfor
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// policyDevice 模拟用户上网策略的查询与修改
+func policyDevice(t *testing.T) (*AC, *acTestDevice) {
+	policies := []string{"p1"}
+	return newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		switch endpoint {
+		case acStatusVersion:
+			return "AC13.0.15.097", nil
+		case acUserNetPolicy:
+			var body map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if r.Method == http.MethodPost && r.URL.Query().Get("_method") == "" {
+				policies = append(policies, "p2")
+				return "ok", nil
+			}
+			return append([]string(nil), policies...), nil
+		}
+		return nil, nil
+	})
+}
+
+// TestCacheAuditState 审计记录的变更后状态不能来自变更前读取时缓存的结果
+func TestCacheAuditState(t *testing.T) {
+	ac, _ := policyDevice(t)
+	var rec *AuditRecord
+	ac.Audit = AuditSinkFunc(func(r *AuditRecord) error {
+		rec = r
+		return nil
+	})
+	ac.AuditState = true
+	ac.Use(NewCache(time.Minute).Interceptor())
+	if _, err := ac.UserNetPolicySet(UserPolicySet{Opr: "add", User: "a", Policy: []string{"p2"}, Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	if rec == nil {
+		t.Fatal("no audit record")
+	}
+	if !reflect.DeepEqual(rec.Before, []string{"p1"}) || !reflect.DeepEqual(rec.After, []string{"p1", "p2"}) {
+		t.Fatalf("before = %v, after = %v", rec.Before, rec.After)
+	}
+	got, err := ac.UserNetPolicyGet("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []string{"p1", "p2"}) {
+		t.Fatalf("policies after change = %v", got)
+	}
+}
+
+func TestCacheHitAndInvalidate(t *testing.T) {
+	ac, d := policyDevice(t)
+	ac.Use(NewCache(time.Minute).Interceptor())
+	count := func() int {
+		n := 0
+		for _, c := range d.calls {
+			if c == acUserNetPolicy {
+				n++
+			}
+		}
+		return n
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := ac.UserNetPolicyGet("a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := count(); n != 1 {
+		t.Fatalf("device queried %d times, want 1", n)
+	}
+	if _, err := ac.UserNetPolicySet(UserPolicySet{Opr: "add", User: "a", Policy: []string{"p2"}, Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ac.UserNetPolicyGet("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []string{"p1", "p2"}) {
+		t.Fatalf("policies after change = %v, want fresh result", got)
+	}
+}
@@ -0,0 +1,194 @@
+/**
+ * @Description: sangfor ac clock drift detection
+ * @File:  clock
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	acTimeLayout = `2006-01-02 15:04:05` // 设备时间格式(系统时间,账号过期时间)
+	acDateLayout = `2006-01-02`          // 设备日期格式(用户详情中的过期日期)
+
+	acClockDefaultSamples = 5 // 时钟同步默认采样次数
+)
+
+// ClockSample 单次时钟采样
+type ClockSample struct {
+	Sent   time.Time     // 本地发起请求时间
+	Recv   time.Time     // 本地收到响应时间
+	Device time.Time     // 设备返回的系统时间(已按设备时区解析)
+	RTT    time.Duration // 往返耗时
+	Offset time.Duration // 本次采样估算的偏移(设备时间-本地时间)
+}
+
+// ClockSyncResult 时钟同步结果
+type ClockSyncResult struct {
+	Offset   time.Duration // 设备时钟相对本地时钟的偏移,正数表示设备时间快于本地
+	RTT      time.Duration // 最小往返耗时
+	Error    time.Duration // 偏移估算误差上限(±)
+	SyncedAt time.Time     // 同步完成时间(本地时间)
+	Samples  []ClockSample // 所有采样
+}
+
+// acClock 缓存在AC对象上的时钟偏移
+type acClock struct {
+	mu     sync.RWMutex
+	result *ClockSyncResult
+}
+
+// ClockSync 通过多次调用 GetSysTime 测量设备时钟相对本地时钟的偏移和往返耗时,
+// 结果缓存在AC对象上,后续的过期时间,在线时长换算都会使用该偏移.
+// samples为采样次数,小于等于0时使用默认值5次.
+// 设备时间只精确到秒,采样会在一秒内均匀分布,以各次采样区间的交集收敛偏移
+func (ac *AC) ClockSync(samples int) (*ClockSyncResult, error) {
+	if samples <= 0 {
+		samples = acClockDefaultSamples
+	}
+	var (
+		gap    = time.Second / time.Duration(samples)
+		r      = &ClockSyncResult{}
+		lo, hi time.Duration
+	)
+	for i := 0; i < samples; i++ {
+		if i > 0 {
+			time.Sleep(gap)
+		}
+		sent := time.Now()
+		sysTime, err := ac.GetSysTime()
+		if err != nil {
+			return nil, err
+		}
+		recv := time.Now()
+		device, err := time.ParseInLocation(acTimeLayout, sysTime, ac.location())
+		if err != nil {
+			return nil, err
+		}
+		// 设备返回时间D表示真实设备时间位于[D,D+1s)内,且取值时刻位于[sent,recv]内
+		sLo, sHi := device.Sub(recv), device.Add(time.Second).Sub(sent)
+		sample := ClockSample{
+			Sent:   sent,
+			Recv:   recv,
+			Device: device,
+			RTT:    recv.Sub(sent),
+			Offset: (sLo + sHi) / 2,
+		}
+		r.Samples = append(r.Samples, sample)
+		if i == 0 || sample.RTT < r.RTT {
+			r.RTT = sample.RTT
+		}
+		if i == 0 || sLo > lo {
+			lo = sLo
+		}
+		if i == 0 || sHi < hi {
+			hi = sHi
+		}
+	}
+	if lo <= hi {
+		r.Offset, r.Error = (lo+hi)/2, (hi-lo)/2
+	} else {
+		// 区间无交集(网络抖动或设备时钟跳变),退回使用往返耗时最小的采样
+		best := r.Samples[0]
+		for _, s := range r.Samples[1:] {
+			if s.RTT < best.RTT {
+				best = s
+			}
+		}
+		r.Offset, r.Error = best.Offset, (best.RTT+time.Second)/2
+	}
+	r.SyncedAt = time.Now()
+	ac.clock.mu.Lock()
+	ac.clock.result = r
+	ac.clock.mu.Unlock()
+	return r, nil
+}
+
+// ClockOffset 返回缓存的设备时钟偏移,尚未同步时返回0
+func (ac *AC) ClockOffset() time.Duration {
+	ac.clock.mu.RLock()
+	defer ac.clock.mu.RUnlock()
+	if ac.clock.result == nil {
+		return 0
+	}
+	return ac.clock.result.Offset
+}
+
+// LastClockSync 返回最近一次时钟同步结果,尚未同步时返回nil
+func (ac *AC) LastClockSync() *ClockSyncResult {
+	ac.clock.mu.RLock()
+	defer ac.clock.mu.RUnlock()
+	return ac.clock.result
+}
+
+// DeviceNow 按缓存偏移推算设备当前时间
+func (ac *AC) DeviceNow() time.Time {
+	return time.Now().Add(ac.ClockOffset()).In(ac.location())
+}
+
+// ParseDeviceTime 将设备返回的无时区时间(e.g:2017-12-13 17:52:11或2017-12-13)
+// 按设备时区解析,并扣除时钟偏移换算为真实时间
+func (ac *AC) ParseDeviceTime(value string) (time.Time, error) {
+	return acParseDeviceTime(value, ac.location(), ac.ClockOffset())
+}
+
+// acParseDeviceTime 按时区loc解析设备时间并扣除时钟偏移offset
+func acParseDeviceTime(value string, loc *time.Location, offset time.Duration) (time.Time, error) {
+	t, err := time.ParseInLocation(acTimeLayout, value, loc)
+	if err != nil {
+		var dErr error
+		t, dErr = time.ParseInLocation(acDateLayout, value, loc)
+		if dErr != nil {
+			return time.Time{}, err
+		}
+	}
+	return t.Add(-offset), nil
+}
+
+// FormatDeviceTime 将真实时间加上时钟偏移后按设备时区格式化,用于设置账号过期时间等字段
+func (ac *AC) FormatDeviceTime(t time.Time) string {
+	return t.Add(ac.ClockOffset()).In(ac.location()).Format(acTimeLayout)
+}
+
+// UserExpireTime 换算用户的账号过期时间(真实时间),ok为false表示账号不过期
+// 设备只返回过期日期,按该日结束(次日零点)计算
+func (ac *AC) UserExpireTime(user *UserDetail) (expire time.Time, ok bool, err error) {
+	if user == nil {
+		return time.Time{}, false, acErrArg()
+	}
+	if !user.ExpireTime.Enable || user.ExpireTime.Date == "" {
+		return time.Time{}, false, nil
+	}
+	t, err := ac.ParseDeviceTime(user.ExpireTime.Date)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if len(user.ExpireTime.Date) == len(acDateLayout) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, true, nil
+}
+
+// OnlineSince 换算在线用户的上线时间(真实时间)
+func (ac *AC) OnlineSince(user OnlineUser) time.Time {
+	return time.Unix(int64(user.LoginTime), 0).Add(-ac.ClockOffset())
+}
+
+// OnlineDuration 换算在线用户的在线时长,设备未返回上线时间时使用 OnlineTime(秒)
+func (ac *AC) OnlineDuration(user OnlineUser) time.Duration {
+	if user.LoginTime <= 0 {
+		return time.Duration(user.OnlineTime) * time.Second
+	}
+	return time.Since(ac.OnlineSince(user))
+}
+
+func (ac *AC) location() *time.Location {
+	if ac.Location != nil {
+		return ac.Location
+	}
+	return time.Local
+}
@@ -0,0 +1,91 @@
+package sangfor
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseDeviceTime(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	cases := []struct {
+		name   string
+		offset time.Duration
+		value  string
+		want   time.Time
+		err    bool
+	}{
+		{"datetime", 0, "2021-03-04 10:00:00", time.Date(2021, 3, 4, 10, 0, 0, 0, loc), false},
+		{"date only", 0, "2021-03-04", time.Date(2021, 3, 4, 0, 0, 0, 0, loc), false},
+		{"device ahead", time.Minute, "2021-03-04 10:00:00", time.Date(2021, 3, 4, 9, 59, 0, 0, loc), false},
+		{"device behind", -30 * time.Second, "2021-03-04 10:00:00", time.Date(2021, 3, 4, 10, 0, 30, 0, loc), false},
+		{"invalid", 0, "2021/03/04", time.Time{}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ac := NewAC("127.0.0.1:9999", "secret")
+			ac.Location = loc
+			ac.clock.result = &ClockSyncResult{Offset: c.offset}
+			got, err := ac.ParseDeviceTime(c.value)
+			if (err != nil) != c.err {
+				t.Fatalf("err = %v, want error %v", err, c.err)
+			}
+			if !c.err && !got.Equal(c.want) {
+				t.Fatalf("got %v, want %v", got, c.want)
+			}
+			if !c.err && len(c.value) == len(acTimeLayout) {
+				if back := ac.FormatDeviceTime(got); back != c.value {
+					t.Fatalf("FormatDeviceTime = %s, want %s", back, c.value)
+				}
+			}
+		})
+	}
+}
+
+func TestUserExpireTime(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	ac := NewAC("127.0.0.1:9999", "secret")
+	ac.Location = loc
+	cases := []struct {
+		name   string
+		enable bool
+		date   string
+		want   time.Time
+		ok     bool
+	}{
+		{"disabled", false, "2021-03-04", time.Time{}, false},
+		{"empty", true, "", time.Time{}, false},
+		{"date is end of day", true, "2021-03-04", time.Date(2021, 3, 5, 0, 0, 0, 0, loc), true},
+		{"datetime", true, "2021-03-04 12:00:00", time.Date(2021, 3, 4, 12, 0, 0, 0, loc), true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			u := &UserDetail{}
+			u.ExpireTime.Enable, u.ExpireTime.Date = c.enable, c.date
+			got, ok, err := ac.UserExpireTime(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != c.ok || !got.Equal(c.want) {
+				t.Fatalf("got %v %v, want %v %v", got, ok, c.want, c.ok)
+			}
+		})
+	}
+}
+
+func TestClockSync(t *testing.T) {
+	const offset = time.Hour
+	ac, _ := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		return time.Now().Add(offset).In(time.Local).Format(acTimeLayout), nil
+	})
+	r, err := ac.ClockSync(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := r.Offset - offset; d < -time.Second || d > time.Second {
+		t.Fatalf("offset = %v, want %v±1s", r.Offset, offset)
+	}
+	if ac.ClockOffset() != r.Offset {
+		t.Fatalf("cached offset = %v, want %v", ac.ClockOffset(), r.Offset)
+	}
+}
@@ -0,0 +1,87 @@
+/**
+ * @Description: gateway request auditing
+ * @File:  audit
+ * @Version: 1.0.0
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditEntry 一次网关请求的审计记录
+type auditEntry struct {
+	Time     time.Time   `json:"time"`
+	Key      string      `json:"key"` // 调用方名称
+	Remote   string      `json:"remote"`
+	Method   string      `json:"method"`
+	Path     string      `json:"path"`
+	Query    string      `json:"query,omitempty"`
+	Body     interface{} `json:"body,omitempty"` // 已脱敏的请求体
+	Status   int         `json:"status"`
+	Duration string      `json:"duration"`
+	Error    string      `json:"error,omitempty"`
+}
+
+type auditLog struct {
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+func newAuditLog(path string) (*auditLog, error) {
+	if path == "" {
+		return &auditLog{w: os.Stdout}, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{w: f}, nil
+}
+
+func (a *auditLog) Write(e auditEntry) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.w.Write(append(line, '\n'))
+}
+
+func (a *auditLog) Close() error {
+	if a.w == os.Stdout {
+		return nil
+	}
+	return a.w.Close()
+}
+
+// sanitize 去除请求体中的密码等敏感字段
+func sanitize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		r := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			if _, isMap := val.(map[string]interface{}); !isMap &&
+				(strings.Contains(strings.ToLower(k), "pass") || strings.Contains(strings.ToLower(k), "secret")) {
+				r[k] = "******"
+				continue
+			}
+			r[k] = sanitize(val)
+		}
+		return r
+	case []interface{}:
+		r := make([]interface{}, len(t))
+		for i, val := range t {
+			r[i] = sanitize(val)
+		}
+		return r
+	}
+	return v
+}
@@ -0,0 +1,624 @@
+/**
+ * @Description: gateway routing, authentication and handlers
+ * @File:  gateway
+ * @Version: 1.0.0
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"sangfor"
+)
+
+// 权限
+const (
+	scopeAll           = "*"
+	scopeStatusRead    = "status:read"
+	scopeUsersRead     = "users:read"
+	scopeUsersWrite    = "users:write"
+	scopeUsersVerify   = "users:verify" // 校验密码,不包含在其他权限中
+	scopeOnlineRead    = "online:read"
+	scopeOnlineWrite   = "online:write"
+	scopeGroupsRead    = "groups:read"
+	scopeGroupsWrite   = "groups:write"
+	scopePoliciesRead  = "policies:read"
+	scopeBindingsRead  = "bindings:read"
+	scopeBindingsWrite = "bindings:write"
+)
+
+// httpError 带HTTP状态码的错误
+type httpError struct {
+	code int
+	msg  string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{code: http.StatusBadRequest, msg: fmt.Sprintf(format, args...)}
+}
+
+// call 单次请求上下文
+type call struct {
+	ac   *sangfor.AC
+	r    *http.Request
+	vars map[string]string
+	body []byte
+}
+
+// decode 解析JSON请求体
+func (c *call) decode(v interface{}) error {
+	if len(bytes.TrimSpace(c.body)) == 0 {
+		return badRequest("request body is required")
+	}
+	dec := json.NewDecoder(bytes.NewReader(c.body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return badRequest("invalid request body: %v", err)
+	}
+	return nil
+}
+
+func (c *call) query(name string) string {
+	return c.r.URL.Query().Get(name)
+}
+
+// queryList 逗号分隔的查询参数
+func (c *call) queryList(name string) []string {
+	v := c.query(name)
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
+
+func (c *call) queryInt(name string) (int, error) {
+	v := c.query(name)
+	if v == "" {
+		return 0, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, badRequest("invalid query parameter %s: %q", name, v)
+	}
+	return i, nil
+}
+
+// route 路由定义,同时用于生成OpenAPI文档
+type route struct {
+	method  string
+	pattern string // 路径,{name}匹配单段,{name*}匹配剩余所有段(组路径)
+	scope   string
+	summary string
+	query   []queryParam
+	body    interface{}  // 请求体类型样例,nil表示无请求体
+	resp    interface{}  // 响应data类型样例,nil表示无数据
+	status  int          // 成功时的状态码,为0时为200
+	limit   *rateLimiter // 按路径变量name限制请求频率,nil表示不限制
+	handle  func(c *call) (interface{}, error)
+}
+
+type queryParam struct {
+	name string
+	desc string
+}
+
+// match 匹配路径,返回路径变量
+func (rt *route) match(path string) (map[string]string, bool) {
+	var (
+		pSegs = strings.Split(strings.Trim(rt.pattern, "/"), "/")
+		segs  = strings.Split(strings.Trim(path, "/"), "/")
+		vars  = make(map[string]string)
+	)
+	for i, p := range pSegs {
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "*}") {
+			if i >= len(segs) {
+				return nil, false
+			}
+			vars[p[1:len(p)-2]] = "/" + strings.Join(segs[i:], "/")
+			return vars, true
+		}
+		if i >= len(segs) {
+			return nil, false
+		}
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			if segs[i] == "" {
+				return nil, false
+			}
+			vars[p[1:len(p)-1]] = segs[i]
+			continue
+		}
+		if p != segs[i] {
+			return nil, false
+		}
+	}
+	return vars, len(pSegs) == len(segs)
+}
+
+// validVar 路径变量不能包含查询字符串分隔符及控制字符,避免拼接到AC请求中注入其他参数
+func validVar(v string) bool {
+	return !strings.ContainsAny(v, "&=?#") && strings.IndexFunc(v, unicode.IsControl) < 0
+}
+
+// gateway HTTP处理器
+type gateway struct {
+	ac     *sangfor.AC
+	keys   []APIKey
+	audit  *auditLog
+	routes []*route
+}
+
+func newGateway(ac *sangfor.AC, keys []APIKey, audit *auditLog) *gateway {
+	return &gateway{ac: ac, keys: keys, audit: audit, routes: routes()}
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if r.Method == http.MethodGet && r.URL.Path == "/v1/openapi.json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(openAPI(g.routes))
+		return
+	}
+	entry := auditEntry{
+		Time:   start,
+		Remote: r.RemoteAddr,
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.RawQuery,
+	}
+	status, err := g.serve(w, r, &entry)
+	entry.Status, entry.Duration = status, time.Since(start).String()
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	g.audit.Write(entry)
+}
+
+func (g *gateway) serve(w http.ResponseWriter, r *http.Request, entry *auditEntry) (int, error) {
+	key := g.authenticate(r)
+	if key == nil {
+		return writeError(w, &httpError{code: http.StatusUnauthorized, msg: "invalid api key"})
+	}
+	entry.Key = key.Name
+	var (
+		matched *route
+		vars    map[string]string
+		allowed []string
+	)
+	for _, rt := range g.routes {
+		v, ok := rt.match(r.URL.Path)
+		if !ok {
+			continue
+		}
+		if rt.method != r.Method {
+			allowed = append(allowed, rt.method)
+			continue
+		}
+		matched, vars = rt, v
+		break
+	}
+	if matched == nil {
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			return writeError(w, &httpError{code: http.StatusMethodNotAllowed, msg: "method not allowed"})
+		}
+		return writeError(w, &httpError{code: http.StatusNotFound, msg: "not found"})
+	}
+	if !key.allowed(matched.scope) {
+		return writeError(w, &httpError{code: http.StatusForbidden, msg: "permission denied: " + matched.scope + " required"})
+	}
+	for name, v := range vars {
+		if !validVar(v) {
+			return writeError(w, badRequest("invalid path parameter %s: %q", name, v))
+		}
+	}
+	if matched.limit != nil {
+		if wait := matched.limit.reserve(vars["name"], time.Now()); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+			return writeError(w, &httpError{code: http.StatusTooManyRequests, msg: "too many requests"})
+		}
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		return writeError(w, badRequest("read request body: %v", err))
+	}
+	if len(body) > 0 {
+		var v interface{}
+		if json.Unmarshal(body, &v) == nil {
+			entry.Body = sanitize(v)
+		}
+	}
+	data, err := matched.handle(&call{ac: g.ac.WithContext(sangfor.WithOperator(r.Context(), key.Name)), r: r, vars: vars, body: body})
+	if err != nil {
+		return writeError(w, err)
+	}
+	status := matched.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return writeJSON(w, status, map[string]interface{}{"data": data}), nil
+}
+
+// authenticate 校验API Key,支持X-API-Key请求头或Authorization: Bearer
+func (g *gateway) authenticate(r *http.Request) *APIKey {
+	token := r.Header.Get("X-API-Key")
+	if token == "" {
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		}
+	}
+	if token == "" {
+		return nil
+	}
+	for i := range g.keys {
+		if subtle.ConstantTimeCompare([]byte(g.keys[i].Key), []byte(token)) == 1 {
+			return &g.keys[i]
+		}
+	}
+	return nil
+}
+
+func (k *APIKey) allowed(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scopeAll || s == scope {
+			return true
+		}
+		// 写权限包含同类读权限
+		if strings.HasSuffix(scope, ":read") && s == strings.TrimSuffix(scope, ":read")+":write" {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) int {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+	return status
+}
+
+// writeError 输出错误,本地参数校验错误使用400,设备不支持的接口使用501,AC返回的错误使用502
+func writeError(w http.ResponseWriter, err error) (int, error) {
+	var (
+		status = http.StatusBadGateway
+		he     *httpError
+		pe     *sangfor.PolicyNameError
+	)
+	switch {
+	case errors.As(err, &he):
+		status = he.code
+	case errors.As(err, &pe), sangfor.IsArgError(err):
+		status = http.StatusBadRequest
+	case errors.Is(err, sangfor.ErrUnsupported):
+		status = http.StatusNotImplemented
+	}
+	return writeJSON(w, status, map[string]string{"error": err.Error()}), err
+}
+
+// rateLimiter 按键(如用户名)限制每个时间窗口内的请求次数
+type rateLimiter struct {
+	max    int
+	window time.Duration
+
+	mu   sync.Mutex
+	hits map[string][]time.Time
+}
+
+func newRateLimiter(max int, window time.Duration) *rateLimiter {
+	return &rateLimiter{max: max, window: window, hits: make(map[string][]time.Time)}
+}
+
+// reserve 记录一次请求,超过限制时不记录并返回需等待的时长
+func (l *rateLimiter) reserve(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k, hits := range l.hits {
+		kept := hits[:0]
+		for _, t := range hits {
+			if now.Sub(t) < l.window {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) == 0 {
+			delete(l.hits, k)
+		} else {
+			l.hits[k] = kept
+		}
+	}
+	hits := l.hits[key]
+	if len(hits) >= l.max {
+		return l.window - now.Sub(hits[0])
+	}
+	l.hits[key] = append(hits, now)
+	return 0
+}
+
+// policyChange 用户/组策略变更请求体
+type policyChange struct {
+	Opr    string   `json:"opr"`    // add/del/modify
+	Policy []string `json:"policy"` // 策略名列表
+	Force  bool     `json:"force"`  // 允许未知,禁用或过期的策略
+}
+
+func (p *policyChange) validate() error {
+	switch p.Opr {
+	case "add", "del", "modify":
+		return nil
+	}
+	return badRequest("invalid opr %q, expected add/del/modify", p.Opr)
+}
+
+// groupCreate 添加组请求体
+type groupCreate struct {
+	Path string `json:"path"`
+	Desc string `json:"desc,omitempty"`
+}
+
+// groupUpdate 修改组请求体
+type groupUpdate struct {
+	Desc string `json:"desc"`
+}
+
+// passwordVerify 校验密码请求体
+type passwordVerify struct {
+	Password string `json:"password"`
+}
+
+// message AC返回的文字结果
+type message string
+
+func routes() []*route {
+	return []*route{
+		// 状态
+		{method: "GET", pattern: "/v1/status/version", scope: scopeStatusRead, summary: "获取版本信息", resp: "",
+			handle: func(c *call) (interface{}, error) { return c.ac.GetVersion() }},
+		{method: "GET", pattern: "/v1/status/online-user-count", scope: scopeStatusRead, summary: "获取在线用户数", resp: 0,
+			handle: func(c *call) (interface{}, error) { return c.ac.GetOnlineUserCount() }},
+		{method: "GET", pattern: "/v1/status/session-num", scope: scopeStatusRead, summary: "获取当前设备会话数", resp: 0,
+			handle: func(c *call) (interface{}, error) { return c.ac.GetSessionNum() }},
+		{method: "GET", pattern: "/v1/status/insidelib", scope: scopeStatusRead, summary: "获取设备内置库版本信息", resp: []sangfor.InsideLib{},
+			handle: func(c *call) (interface{}, error) { return c.ac.GetInsideLib() }},
+		{method: "GET", pattern: "/v1/status/log-num", scope: scopeStatusRead, summary: "获取日志计数统计", resp: sangfor.LogNum{},
+			handle: func(c *call) (interface{}, error) { return c.ac.GetLogNum() }},
+		{method: "GET", pattern: "/v1/status/cpu-usage", scope: scopeStatusRead, summary: "获取CPU使用率", resp: 0,
+			handle: func(c *call) (interface{}, error) { return c.ac.GetCpuUsage() }},
+		{method: "GET", pattern: "/v1/status/mem-usage", scope: scopeStatusRead, summary: "获取内存使用率", resp: 0,
+			handle: func(c *call) (interface{}, error) { return c.ac.GetMemUsage() }},
+		{method: "GET", pattern: "/v1/status/disk-usage", scope: scopeStatusRead, summary: "获取磁盘使用率", resp: 0,
+			handle: func(c *call) (interface{}, error) { return c.ac.GetDiskUsage() }},
+		{method: "GET", pattern: "/v1/status/sys-time", scope: scopeStatusRead, summary: "获取设备的当前系统时间", resp: "",
+			handle: func(c *call) (interface{}, error) { return c.ac.GetSysTime() }},
+		{method: "GET", pattern: "/v1/status/throughput", scope: scopeStatusRead, summary: "获取设备当前上行和下行流量", resp: sangfor.Throughput{},
+			query: []queryParam{{"unit", "流量单位(bits/bytes)"}, {"interface", "接口名称"}},
+			handle: func(c *call) (interface{}, error) {
+				return c.ac.GetThroughput(sangfor.ThroughputFilter{Unit: c.query("unit"), Interface: c.query("interface")})
+			}},
+		{method: "GET", pattern: "/v1/status/user-rank", scope: scopeStatusRead, summary: "获取用户流量排行", resp: []sangfor.UserRank{},
+			query: []queryParam{{"top", "TopN排行"}, {"line", "线路号"}, {"groups", "过滤组(逗号分隔)"}, {"users", "过滤用户(逗号分隔)"}, {"ips", "过滤IP(逗号分隔)"}},
+			handle: func(c *call) (interface{}, error) {
+				top, err := c.queryInt("top")
+				if err != nil {
+					return nil, err
+				}
+				return c.ac.GetUserRank(sangfor.UserRankFilter{Top: top, Line: c.query("line"),
+					Groups: c.queryList("groups"), Users: c.queryList("users"), Ips: c.queryList("ips")})
+			}},
+		{method: "GET", pattern: "/v1/status/app-rank", scope: scopeStatusRead, summary: "获取应用流量排行", resp: []sangfor.AppRank{},
+			query: []queryParam{{"top", "TopN排行"}, {"line", "线路号"}, {"groups", "过滤组(逗号分隔)"}},
+			handle: func(c *call) (interface{}, error) {
+				top, err := c.queryInt("top")
+				if err != nil {
+					return nil, err
+				}
+				return c.ac.GetAppRank(sangfor.AppRankFilter{Top: top, Line: c.query("line"), Groups: c.queryList("groups")})
+			}},
+		{method: "GET", pattern: "/v1/status/bandwidth-usage", scope: scopeStatusRead, summary: "获取带宽利用率", resp: 0,
+			handle: func(c *call) (interface{}, error) { return c.ac.GetBandwidthUsage() }},
+
+		// 用户
+		{method: "GET", pattern: "/v1/users", scope: scopeUsersRead, summary: "搜索用户(最多返回100个)", resp: []sangfor.UserDetail{},
+			query: []queryParam{{"type", "搜索类型(user/ip/mac),默认user"}, {"value", "搜索值(用户名,IP段或MAC)"},
+				{"group", "所在组"}, {"status", "用户状态(all/enabled/disabled)"}, {"public", "只搜索多人共用账号(true)"}},
+			handle: searchUsers},
+		{method: "GET", pattern: "/v1/users/{name}", scope: scopeUsersRead, summary: "获取用户详细信息", resp: sangfor.UserDetail{},
+			handle: func(c *call) (interface{}, error) { return c.ac.UserGet(c.vars["name"]) }},
+		{method: "POST", pattern: "/v1/users", scope: scopeUsersWrite, summary: "添加用户", body: sangfor.UserAdd{}, resp: message(""), status: http.StatusCreated,
+			handle: func(c *call) (interface{}, error) {
+				var data sangfor.UserAdd
+				if err := c.decode(&data); err != nil {
+					return nil, err
+				}
+				return c.ac.UserAdd(data)
+			}},
+		{method: "PUT", pattern: "/v1/users/{name}", scope: scopeUsersWrite, summary: "修改用户信息", body: sangfor.UserMod{}.Data, resp: message(""),
+			handle: func(c *call) (interface{}, error) {
+				mod := sangfor.UserMod{Name: c.vars["name"]}
+				if err := c.decode(&mod.Data); err != nil {
+					return nil, err
+				}
+				return c.ac.UserMod(mod)
+			}},
+		{method: "DELETE", pattern: "/v1/users/{name}", scope: scopeUsersWrite, summary: "删除用户", resp: message(""),
+			handle: func(c *call) (interface{}, error) { return c.ac.UserDel(c.vars["name"]) }},
+		{method: "GET", pattern: "/v1/users/{name}/netpolicy", scope: scopeUsersRead, summary: "获取用户关联的上网策略", resp: []string{},
+			handle: func(c *call) (interface{}, error) { return c.ac.UserNetPolicyGet(c.vars["name"]) }},
+		{method: "PUT", pattern: "/v1/users/{name}/netpolicy", scope: scopeUsersWrite, summary: "设置用户的上网策略", body: policyChange{}, resp: message(""),
+			handle: func(c *call) (interface{}, error) {
+				var p policyChange
+				if err := c.decode(&p); err != nil {
+					return nil, err
+				}
+				if err := p.validate(); err != nil {
+					return nil, err
+				}
+				return c.ac.UserNetPolicySet(sangfor.UserPolicySet{Opr: p.Opr, User: c.vars["name"], Policy: p.Policy, Force: p.Force})
+			}},
+		{method: "GET", pattern: "/v1/users/{name}/fluxpolicy", scope: scopeUsersRead, summary: "获取用户关联的流控策略", resp: []string{},
+			handle: func(c *call) (interface{}, error) { return c.ac.UserFluxPolicyGet(c.vars["name"]) }},
+		{method: "PUT", pattern: "/v1/users/{name}/fluxpolicy", scope: scopeUsersWrite, summary: "设置用户的流控策略", body: policyChange{}, resp: message(""),
+			handle: func(c *call) (interface{}, error) {
+				var p policyChange
+				if err := c.decode(&p); err != nil {
+					return nil, err
+				}
+				if err := p.validate(); err != nil {
+					return nil, err
+				}
+				return c.ac.UserFluxPolicySet(sangfor.UserPolicySet{Opr: p.Opr, User: c.vars["name"], Policy: p.Policy, Force: p.Force})
+			}},
+		{method: "POST", pattern: "/v1/users/{name}/verify", scope: scopeUsersVerify, summary: "校验本地用户密码(每个用户每分钟最多5次)", body: passwordVerify{},
+			limit: newRateLimiter(5, time.Minute),
+			handle: func(c *call) (interface{}, error) {
+				var p passwordVerify
+				if err := c.decode(&p); err != nil {
+					return nil, err
+				}
+				return nil, c.ac.UserVerifyPassword(c.vars["name"], p.Password)
+			}},
+
+		// 在线用户
+		{method: "GET", pattern: "/v1/online-users", scope: scopeOnlineRead, summary: "获取在线用户列表(最多返回100个)", resp: sangfor.OnlineUsers{},
+			query: []queryParam{{"status", "用户状态(all/frozen/active)"}, {"terminal", "终端类型"},
+				{"type", "搜索类型(user/ip/mac)"}, {"value", "搜索值(逗号分隔)"}},
+			handle: func(c *call) (interface{}, error) {
+				filter := sangfor.OnlineUserGet{Status: c.query("status"), Terminal: c.query("terminal")}
+				if t := c.query("type"); t != "" {
+					filter.Filter = &sangfor.OnlineUserGetFilter{Type: t, Value: c.queryList("value")}
+				}
+				return c.ac.OnlineUserGet(filter)
+			}},
+		{method: "POST", pattern: "/v1/online-users", scope: scopeOnlineWrite, summary: "上线在线用户(单点登录)", body: sangfor.OnlineUserUp{}, status: http.StatusCreated,
+			handle: func(c *call) (interface{}, error) {
+				var user sangfor.OnlineUserUp
+				if err := c.decode(&user); err != nil {
+					return nil, err
+				}
+				return nil, c.ac.OnlineUserUp(user)
+			}},
+		{method: "DELETE", pattern: "/v1/online-users/{ip}", scope: scopeOnlineWrite, summary: "强制注销在线用户",
+			handle: func(c *call) (interface{}, error) { return nil, c.ac.OnlineUserKick(c.vars["ip"]) }},
+
+		// 组
+		{method: "POST", pattern: "/v1/groups", scope: scopeGroupsWrite, summary: "添加组", body: groupCreate{}, resp: message(""), status: http.StatusCreated,
+			handle: func(c *call) (interface{}, error) {
+				var g groupCreate
+				if err := c.decode(&g); err != nil {
+					return nil, err
+				}
+				if !strings.HasPrefix(g.Path, "/") {
+					return nil, badRequest("group path must start with /")
+				}
+				return c.ac.GroupAdd(g.Path, g.Desc)
+			}},
+		{method: "PUT", pattern: "/v1/groups/{path*}", scope: scopeGroupsWrite, summary: "修改组描述", body: groupUpdate{}, resp: message(""),
+			handle: func(c *call) (interface{}, error) {
+				var g groupUpdate
+				if err := c.decode(&g); err != nil {
+					return nil, err
+				}
+				return c.ac.GroupPut(c.vars["path"], g.Desc)
+			}},
+		{method: "DELETE", pattern: "/v1/groups/{path*}", scope: scopeGroupsWrite, summary: "删除组", resp: message(""),
+			handle: func(c *call) (interface{}, error) { return c.ac.GroupDelete(c.vars["path"]) }},
+		{method: "GET", pattern: "/v1/group-netpolicy/{path*}", scope: scopeGroupsRead, summary: "获取组关联的上网策略", resp: []string{},
+			handle: func(c *call) (interface{}, error) { return c.ac.GroupNetPolicyGet(c.vars["path"]) }},
+		{method: "PUT", pattern: "/v1/group-netpolicy/{path*}", scope: scopeGroupsWrite, summary: "设置组关联的上网策略", body: policyChange{}, resp: message(""),
+			handle: func(c *call) (interface{}, error) {
+				var p policyChange
+				if err := c.decode(&p); err != nil {
+					return nil, err
+				}
+				if err := p.validate(); err != nil {
+					return nil, err
+				}
+				return c.ac.GroupNetPolicySet(sangfor.GroupPolicySet{Opr: p.Opr, Group: c.vars["path"], Policy: p.Policy, Force: p.Force})
+			}},
+
+		// 策略
+		{method: "GET", pattern: "/v1/policies/net", scope: scopePoliciesRead, summary: "获取设备已有上网策略", resp: []sangfor.NetPolicy{},
+			handle: func(c *call) (interface{}, error) { return c.ac.PolicyNetGet() }},
+		{method: "GET", pattern: "/v1/policies/flux", scope: scopePoliciesRead, summary: "获取设备已有流控策略", resp: []sangfor.FluxPolicy{},
+			handle: func(c *call) (interface{}, error) { return c.ac.PolicyFluxGet() }},
+
+		// 绑定
+		{method: "GET", pattern: "/v1/bindings/users", scope: scopeBindingsRead, summary: "查询用户和IP/MAC的绑定关系",
+			query: []queryParam{{"search", "用户名,IP或MAC"}}, resp: []sangfor.BindUser{},
+			handle: func(c *call) (interface{}, error) { return c.ac.BindUserSearch(c.query("search")) }},
+		{method: "POST", pattern: "/v1/bindings/users", scope: scopeBindingsWrite, summary: "增加用户的IP/MAC绑定", body: sangfor.BindUser{}, resp: message(""), status: http.StatusCreated,
+			handle: func(c *call) (interface{}, error) {
+				var b sangfor.BindUser
+				if err := c.decode(&b); err != nil {
+					return nil, err
+				}
+				return c.ac.BindUserAdd(b)
+			}},
+		{method: "DELETE", pattern: "/v1/bindings/users/{addr}", scope: scopeBindingsWrite, summary: "删除用户和IP/MAC的绑定关系", resp: message(""),
+			handle: func(c *call) (interface{}, error) { return c.ac.BindUserDel(c.vars["addr"]) }},
+		{method: "GET", pattern: "/v1/bindings/ipmac", scope: scopeBindingsRead, summary: "查询IP/MAC绑定关系", resp: sangfor.BindIpMac{},
+			query:  []queryParam{{"search", "IP或MAC"}},
+			handle: func(c *call) (interface{}, error) { return c.ac.BindIpmacSearch(c.query("search")) }},
+		{method: "POST", pattern: "/v1/bindings/ipmac", scope: scopeBindingsWrite, summary: "增加IP/MAC绑定", body: sangfor.BindIpMac{}, status: http.StatusCreated,
+			handle: func(c *call) (interface{}, error) {
+				var b sangfor.BindIpMac
+				if err := c.decode(&b); err != nil {
+					return nil, err
+				}
+				return nil, c.ac.BindIpmacAdd(b)
+			}},
+		{method: "DELETE", pattern: "/v1/bindings/ipmac/{ip}", scope: scopeBindingsWrite, summary: "删除IP/MAC绑定",
+			handle: func(c *call) (interface{}, error) { return nil, c.ac.BindIpmacDel(c.vars["ip"]) }},
+	}
+}
+
+// searchUsers 按查询参数构造 UserSearch
+func searchUsers(c *call) (interface{}, error) {
+	var (
+		search sangfor.UserSearch
+		opts   []sangfor.SearchOption
+		value  = c.query("value")
+	)
+	if g := c.query("group"); g != "" {
+		opts = append(opts, sangfor.InGroup(g))
+	}
+	if s := c.query("status"); s != "" {
+		opts = append(opts, sangfor.Status(sangfor.UserStatus(s)))
+	}
+	if c.query("public") == "true" {
+		opts = append(opts, sangfor.PublicOnly())
+	}
+	switch c.query("type") {
+	case "", sangfor.SearchTypeUser:
+		search = sangfor.SearchByName(value, opts...)
+	case sangfor.SearchTypeIP:
+		r, err := sangfor.ParseIPRange(value)
+		if err != nil {
+			return nil, badRequest("%v", err)
+		}
+		search = sangfor.SearchByIPRange(r, opts...)
+	case sangfor.SearchTypeMAC:
+		m, err := sangfor.ParseMAC(value)
+		if err != nil {
+			return nil, badRequest("%v", err)
+		}
+		search = sangfor.SearchByMAC(m, opts...)
+	default:
+		return nil, badRequest("invalid search type %q", c.query("type"))
+	}
+	return c.ac.UserSearch(search)
+}
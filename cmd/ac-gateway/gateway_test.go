@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sangfor"
+)
+
+func TestWriteError(t *testing.T) {
+	_, macErr := sangfor.ParseMAC("bad")
+	cases := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"http error", badRequest("bad"), http.StatusBadRequest},
+		{"invalid mac", macErr, http.StatusBadRequest},
+		{"unsupported", fmt.Errorf("%w: x", sangfor.ErrUnsupported), http.StatusNotImplemented},
+		{"device error", errors.New("用户不存在"), http.StatusBadGateway},
+		{"device invalid message", errors.New("invalid session"), http.StatusBadGateway},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		if status, _ := writeError(w, c.err); status != c.want || w.Code != c.want {
+			t.Fatalf("%s: status = %d, want %d", c.name, status, c.want)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	var (
+		l   = newRateLimiter(2, time.Minute)
+		now = time.Now()
+	)
+	if l.reserve("a", now) != 0 || l.reserve("a", now) != 0 {
+		t.Fatal("first two requests should pass")
+	}
+	if l.reserve("a", now) == 0 {
+		t.Fatal("third request should be limited")
+	}
+	if l.reserve("b", now) != 0 {
+		t.Fatal("other keys are not limited")
+	}
+	if l.reserve("a", now.Add(time.Minute)) != 0 {
+		t.Fatal("requests should pass after the window")
+	}
+}
+
+func TestVerifyScope(t *testing.T) {
+	read := &APIKey{Scopes: []string{scopeUsersRead, scopeUsersWrite}}
+	if read.allowed(scopeUsersVerify) {
+		t.Fatal("users:read/write must not allow password verification")
+	}
+	if !(&APIKey{Scopes: []string{scopeUsersVerify}}).allowed(scopeUsersVerify) {
+		t.Fatal("users:verify should allow password verification")
+	}
+}
+
+// testDevice 模拟AC,记录收到的请求
+type testDevice struct {
+	*httptest.Server
+	queries []url.Values
+	bodies  []map[string]interface{}
+}
+
+func newTestGateway(t *testing.T) (*gateway, *testDevice) {
+	d := &testDevice{}
+	d.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		d.queries, d.bodies = append(d.queries, r.URL.Query()), append(d.bodies, body)
+		var data interface{} = "ok"
+		if strings.HasSuffix(r.URL.Path, "/user") && r.Method == http.MethodGet {
+			data = map[string]interface{}{"name": r.URL.Query().Get("name")}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"code": 0, "data": data})
+	}))
+	t.Cleanup(d.Close)
+	audit, err := newAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { audit.Close() })
+	keys := []APIKey{
+		{Name: "reader", Key: "read-key", Scopes: []string{scopeUsersRead}},
+		{Name: "admin", Key: "admin-key", Scopes: []string{scopeAll}},
+	}
+	return newGateway(sangfor.NewAC(strings.TrimPrefix(d.URL, "http://"), "secret"), keys, audit), d
+}
+
+func request(g *gateway, method, target string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, r)
+	return w
+}
+
+func TestGatewayAuthAndRoutes(t *testing.T) {
+	g, d := newTestGateway(t)
+	cases := []struct {
+		name   string
+		method string
+		target string
+		header []string
+		want   int
+	}{
+		{"no key", "GET", "/v1/users/alice", nil, http.StatusUnauthorized},
+		{"wrong key", "GET", "/v1/users/alice", []string{"X-API-Key", "nope"}, http.StatusUnauthorized},
+		{"api key header", "GET", "/v1/users/alice", []string{"X-API-Key", "read-key"}, http.StatusOK},
+		{"bearer", "GET", "/v1/users/alice", []string{"Authorization", "Bearer read-key"}, http.StatusOK},
+		{"scope mismatch", "DELETE", "/v1/users/alice", []string{"X-API-Key", "read-key"}, http.StatusForbidden},
+		{"not found", "GET", "/v1/nope", []string{"X-API-Key", "read-key"}, http.StatusNotFound},
+		{"method not allowed", "PATCH", "/v1/users/alice", []string{"X-API-Key", "read-key"}, http.StatusMethodNotAllowed},
+		{"group path", "DELETE", "/v1/groups/a/b", []string{"X-API-Key", "admin-key"}, http.StatusOK},
+	}
+	for _, c := range cases {
+		if w := request(g, c.method, c.target, c.header...); w.Code != c.want {
+			t.Fatalf("%s: status = %d, want %d (%s)", c.name, w.Code, c.want, w.Body)
+		}
+	}
+	if len(d.queries) != 3 || d.queries[0].Get("name") != "alice" || d.bodies[2]["path"] != "/a/b" {
+		t.Fatalf("device got queries %v, bodies %v", d.queries, d.bodies)
+	}
+	if w := request(g, "PATCH", "/v1/users/alice", "X-API-Key", "read-key"); !strings.Contains(w.Header().Get("Allow"), "GET") {
+		t.Fatalf("Allow = %q", w.Header().Get("Allow"))
+	}
+}
+
+// TestGatewayPathInjection 只读权限不能通过路径变量注入_method等AC参数,路径变量按值转义后发送
+func TestGatewayPathInjection(t *testing.T) {
+	g, d := newTestGateway(t)
+	for _, target := range []string{"/v1/users/x%26_method%3DDELETE", "/v1/users/x%3Fpassword%3D1", "/v1/users/x%0A"} {
+		if w := request(g, "GET", target, "X-API-Key", "read-key"); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d, want 400", target, w.Code)
+		}
+	}
+	if len(d.queries) != 0 {
+		t.Fatalf("device got %v, want no requests", d.queries)
+	}
+	if w := request(g, "GET", "/v1/users/"+url.PathEscape("张三+a"), "X-API-Key", "read-key"); w.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s)", w.Code, w.Body)
+	}
+	if q := d.queries[0]; q.Get("name") != "张三+a" || q.Get("_method") != "" {
+		t.Fatalf("device query = %v, want name 张三+a only", q)
+	}
+}
@@ -0,0 +1,122 @@
+/**
+ * @Description: restful json gateway over the sangfor ac api
+ * @File:  main
+ * @Version: 1.0.0
+ */
+
+// ac-gateway 将深信服AC接口以RESTful JSON形式暴露给非Go服务,
+// 调用方使用API Key认证,AC密钥只保存在网关配置中
+//
+// 用法: ac-gateway -config gateway.json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"sangfor"
+)
+
+// Config 网关配置
+type Config struct {
+	Listen    string   `json:"listen"`     // 监听地址,默认:8080
+	Target    string   `json:"target"`     // AC地址(ip+端口)
+	Secret    string   `json:"secret"`     // AC开放接口密钥,为空时读取环境变量AC_SECRET
+	AuditLog  string   `json:"audit_log"`  // 请求审计日志(JSON行),为空时输出到标准输出
+	ChangeLog string   `json:"change_log"` // AC变更审计日志(JSON行,含变更前后状态),为空时不记录
+	Keys      []APIKey `json:"keys"`       // 调用方API Key
+}
+
+// APIKey 调用方API Key及其权限
+// 权限取值见 scopes,"*"表示所有权限
+type APIKey struct {
+	Name   string   `json:"name"`   // 调用方名称,记录在审计日志中
+	Key    string   `json:"key"`    // 通过请求头X-API-Key或Authorization: Bearer传入
+	Scopes []string `json:"scopes"` // 权限列表
+}
+
+func main() {
+	configPath := flag.String("config", "gateway.json", "gateway config file")
+	flag.Parse()
+	if err := run(*configPath); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run 启动网关直到服务退出或收到退出信号,返回前关闭审计日志
+func run(configPath string) error {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	audit, err := newAuditLog(cfg.AuditLog)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	defer audit.Close()
+
+	ac := sangfor.NewAC(cfg.Target, cfg.Secret)
+	if cfg.ChangeLog != "" {
+		sink, err := sangfor.NewFileAuditSink(cfg.ChangeLog)
+		if err != nil {
+			return fmt.Errorf("open change log: %w", err)
+		}
+		defer sink.Close()
+		ac.Audit, ac.AuditState = sink, true
+	}
+	gw := newGateway(ac, cfg.Keys, audit)
+	srv := &http.Server{
+		Addr:         cfg.Listen,
+		Handler:      gw,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 60 * time.Second,
+	}
+	log.Printf("ac-gateway listening on %s, target %s", cfg.Listen, cfg.Target)
+	return serve(srv)
+}
+
+// serve 运行服务直到收到SIGINT/SIGTERM,等待处理中的请求结束后返回
+func serve(srv *http.Server) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	log.Printf("ac-gateway shutting down")
+	shutdown, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdown)
+}
+
+func loadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Listen == "" {
+		cfg.Listen = ":8080"
+	}
+	if cfg.Secret == "" {
+		cfg.Secret = os.Getenv("AC_SECRET")
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,180 @@
+/**
+ * @Description: openapi document generated from routes and exported types
+ * @File:  openapi
+ * @Version: 1.0.0
+ */
+
+package main
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// openAPI 根据路由表生成OpenAPI 3文档
+func openAPI(routes []*route) map[string]interface{} {
+	var (
+		gen   = &schemaGen{defs: make(map[string]interface{})}
+		paths = make(map[string]map[string]interface{})
+	)
+	for _, rt := range routes {
+		path := strings.Replace(rt.pattern, "*}", "}", -1)
+		item, ok := paths[path]
+		if !ok {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+		var params []interface{}
+		for _, seg := range strings.Split(path, "/") {
+			if strings.HasPrefix(seg, "{") {
+				params = append(params, map[string]interface{}{
+					"name": strings.Trim(seg, "{}"), "in": "path", "required": true,
+					"schema": map[string]interface{}{"type": "string"},
+				})
+			}
+		}
+		for _, q := range rt.query {
+			params = append(params, map[string]interface{}{
+				"name": q.name, "in": "query", "description": q.desc,
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		status := rt.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		data := map[string]interface{}{}
+		if rt.resp != nil {
+			data = gen.schema(reflect.TypeOf(rt.resp))
+		}
+		op := map[string]interface{}{
+			"summary":  rt.summary,
+			"security": []interface{}{map[string]interface{}{"apiKey": []string{}}, map[string]interface{}{"bearer": []string{}}},
+			"x-scope":  rt.scope,
+			"responses": map[string]interface{}{
+				strconv.Itoa(status): map[string]interface{}{
+					"description": "OK",
+					"content": jsonContent(map[string]interface{}{
+						"type":       "object",
+						"properties": map[string]interface{}{"data": data},
+					}),
+				},
+				"default": map[string]interface{}{
+					"description": "Error",
+					"content":     jsonContent(map[string]interface{}{"$ref": "#/components/schemas/Error"}),
+				},
+			},
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if rt.body != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(gen.schema(reflect.TypeOf(rt.body))),
+			}
+		}
+		item[strings.ToLower(rt.method)] = op
+	}
+	gen.defs["Error"] = map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"error": map[string]interface{}{"type": "string"}},
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    map[string]interface{}{"title": "Sangfor AC Gateway", "version": "1.0.0"},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": gen.defs,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+// schemaGen 由Go类型反射生成JSON Schema,具名结构体放入components
+type schemaGen struct {
+	defs map[string]interface{}
+}
+
+func (g *schemaGen) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Implements(textMarshalerType) && !t.Implements(jsonMarshalerType):
+		return map[string]interface{}{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 && t.Kind() == reflect.Slice {
+			return map[string]interface{}{"type": "string"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType) {
+			return map[string]interface{}{"type": "object"}
+		}
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name := t.Name()
+		if _, ok := g.defs[name]; !ok {
+			g.defs[name] = nil // 占位,避免递归类型死循环
+			g.defs[name] = g.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+func (g *schemaGen) object(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				name = n
+			}
+		}
+		props[name] = g.schema(f.Type)
+	}
+	return map[string]interface{}{"type": "object", "properties": props}
+}
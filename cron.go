@@ -0,0 +1,170 @@
+/**
+ * @Description: cron expression parser
+ * @File:  cron
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule 标准5段cron表达式(分 时 日 月 周)
+// 支持"*",列表(1,2),范围(1-5),步长(*/5,1-10/2),月份与星期英文缩写(JAN,MON),
+// 以及@yearly,@monthly,@weekly,@daily,@hourly简写.日与周同时限定时满足其一即触发
+type CronSchedule struct {
+	spec                          string
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+var (
+	acCronDescriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+	acCronMonths = map[string]int{"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12}
+	acCronWeekdays = map[string]int{"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6}
+)
+
+// ParseCron 解析cron表达式
+func ParseCron(spec string) (*CronSchedule, error) {
+	expr := strings.TrimSpace(spec)
+	if d, ok := acCronDescriptors[strings.ToLower(expr)]; ok {
+		expr = d
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, acArgErrorf("invalid cron spec %q: expected 5 fields", spec)
+	}
+	var (
+		c   = &CronSchedule{spec: spec}
+		err error
+	)
+	if c.minute, err = acCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, acArgErrorf("invalid cron spec %q: minute: %v", spec, err)
+	}
+	if c.hour, err = acCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, acArgErrorf("invalid cron spec %q: hour: %v", spec, err)
+	}
+	if c.dom, err = acCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, acArgErrorf("invalid cron spec %q: day of month: %v", spec, err)
+	}
+	if c.month, err = acCronField(fields[3], 1, 12, acCronMonths); err != nil {
+		return nil, acArgErrorf("invalid cron spec %q: month: %v", spec, err)
+	}
+	if c.dow, err = acCronField(fields[4], 0, 7, acCronWeekdays); err != nil {
+		return nil, acArgErrorf("invalid cron spec %q: day of week: %v", spec, err)
+	}
+	// 7与0均表示周日
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny, c.dowAny = fields[2] == "*" || fields[2] == "?", fields[4] == "*" || fields[4] == "?"
+	return c, nil
+}
+
+func acCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		var (
+			rng  = part
+			step = 1
+			err  error
+		)
+		if i := strings.Index(part, "/"); i >= 0 {
+			rng = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+		}
+		lo, hi := min, max
+		switch {
+		case rng == "*" || rng == "?":
+		case strings.Contains(rng, "-"):
+			i := strings.Index(rng, "-")
+			if lo, err = acCronValue(rng[:i], names); err != nil {
+				return 0, err
+			}
+			if hi, err = acCronValue(rng[i+1:], names); err != nil {
+				return 0, err
+			}
+		default:
+			if lo, err = acCronValue(rng, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if strings.Contains(part, "/") {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value out of range %q (%d-%d)", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func acCronValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return v, nil
+}
+
+// String 返回原始表达式
+func (c *CronSchedule) String() string {
+	return c.spec
+}
+
+// Next 返回t之后(不含t)的下一次触发时间,按t所在时区计算,5年内无触发时返回零值
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatch(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (c *CronSchedule) dayMatch(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,304 @@
+/**
+ * @Description: policy configuration drift detection between snapshots
+ * @File:  drift
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 变更类型
+const (
+	DriftAdded   = "added"
+	DriftRemoved = "removed"
+	DriftChanged = "changed"
+)
+
+// PolicySnapshot 策略配置快照
+type PolicySnapshot struct {
+	Time   time.Time             `json:"time"`
+	Net    map[string]NetPolicy  `json:"net"`    // 策略名 -> 上网策略
+	Flux   map[string]FluxPolicy `json:"flux"`   // 通道ID(为空时为通道名) -> 流控策略
+	Groups map[string][]string   `json:"groups"` // 组路径 -> 关联的上网策略
+	Users  map[string][]string   `json:"users"`  // 用户名 -> 关联的上网策略
+}
+
+// FieldChange 单个字段的变更,列表字段给出增加和删除的项
+type FieldChange struct {
+	Field   string      `json:"field"`
+	Before  interface{} `json:"before,omitempty"`
+	After   interface{} `json:"after,omitempty"`
+	Added   []string    `json:"added,omitempty"`
+	Removed []string    `json:"removed,omitempty"`
+}
+
+// PolicyChange 单条策略或关联关系的变更
+type PolicyChange struct {
+	Kind   string        `json:"kind"`   // net/flux/group/user
+	Name   string        `json:"name"`   // 策略名,通道名,组路径或用户名
+	Action string        `json:"action"` // DriftAdded/DriftRemoved/DriftChanged
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// DriftReport 两次快照之间的变更报告
+type DriftReport struct {
+	From     time.Time      `json:"from"` // 基线时间
+	To       time.Time      `json:"to"`
+	Baseline bool           `json:"baseline"` // 首次采集,仅建立基线
+	Changes  []PolicyChange `json:"changes"`
+}
+
+// CapturePolicies 采集上网策略,流控策略以及groups和users关联的上网策略
+func (ac *AC) CapturePolicies(groups, users []string) (*PolicySnapshot, error) {
+	s := &PolicySnapshot{
+		Time:   time.Now(),
+		Net:    make(map[string]NetPolicy),
+		Flux:   make(map[string]FluxPolicy),
+		Groups: make(map[string][]string),
+		Users:  make(map[string][]string),
+	}
+	netPolicies, err := ac.PolicyNetGet()
+	if err != nil {
+		return nil, fmt.Errorf("get net policy: %w", err)
+	}
+	for _, p := range netPolicies {
+		s.Net[p.PolicyInfo.Name] = p
+	}
+	fluxPolicies, err := ac.PolicyFluxGet()
+	if err != nil {
+		return nil, fmt.Errorf("get flux policy: %w", err)
+	}
+	for _, p := range fluxPolicies {
+		key := p.Id
+		if key == "" {
+			key = p.Name
+		}
+		s.Flux[key] = p
+	}
+	for _, g := range groups {
+		policies, err := ac.GroupNetPolicyGet(g)
+		if err != nil {
+			return nil, fmt.Errorf("get group %s policy: %w", g, err)
+		}
+		s.Groups[g] = policies
+	}
+	for _, u := range users {
+		policies, err := ac.UserNetPolicyGet(u)
+		if err != nil {
+			return nil, fmt.Errorf("get user %s policy: %w", u, err)
+		}
+		s.Users[u] = policies
+	}
+	return s, nil
+}
+
+// DiffPolicies 比较两次快照
+func DiffPolicies(before, after *PolicySnapshot) *DriftReport {
+	r := &DriftReport{From: before.Time, To: after.Time}
+	for _, name := range acUnionKeys(before.Net, after.Net) {
+		b, bok := before.Net[name]
+		a, aok := after.Net[name]
+		r.add("net", name, bok, aok, append(acDiffFields("", b.PolicyInfo, a.PolicyInfo, true), acDiffFields("user_info.", b.UserInfo, a.UserInfo, true)...))
+	}
+	for _, id := range acUnionKeys(before.Flux, after.Flux) {
+		b, bok := before.Flux[id]
+		a, aok := after.Flux[id]
+		name := a.Name
+		if !aok {
+			name = b.Name
+		}
+		r.add("flux", name, bok, aok, acDiffFields("", b, a, false)) // 带宽数组为[上行,下行],按顺序比较
+	}
+	for _, kind := range []string{"group", "user"} {
+		bm, am := before.Groups, after.Groups
+		if kind == "user" {
+			bm, am = before.Users, after.Users
+		}
+		for _, name := range acUnionKeys(bm, am) {
+			b, bok := bm[name]
+			a, aok := am[name]
+			if !bok || !aok {
+				continue // 未在两次快照中同时采集
+			}
+			r.add(kind, name, true, true, acDiffFields("", struct {
+				Policy []string `json:"policy"`
+			}{b}, struct {
+				Policy []string `json:"policy"`
+			}{a}, true))
+		}
+	}
+	return r
+}
+
+func (r *DriftReport) add(kind, name string, before, after bool, fields []FieldChange) {
+	switch {
+	case !before && after:
+		r.Changes = append(r.Changes, PolicyChange{Kind: kind, Name: name, Action: DriftAdded})
+	case before && !after:
+		r.Changes = append(r.Changes, PolicyChange{Kind: kind, Name: name, Action: DriftRemoved})
+	case len(fields) > 0:
+		r.Changes = append(r.Changes, PolicyChange{Kind: kind, Name: name, Action: DriftChanged, Fields: fields})
+	}
+}
+
+// WriteText 输出文字报告
+func (r *DriftReport) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "策略变更 %s - %s: %d项\n", r.From.Format(acTimeLayout), r.To.Format(acTimeLayout), len(r.Changes))
+	for _, c := range r.Changes {
+		fmt.Fprintf(&b, "[%s] %s %s\n", c.Kind, c.Name, c.Action)
+		for _, f := range c.Fields {
+			switch {
+			case f.Added != nil || f.Removed != nil:
+				fmt.Fprintf(&b, "  %s: +%v -%v\n", f.Field, f.Added, f.Removed)
+			default:
+				fmt.Fprintf(&b, "  %s: %v -> %v\n", f.Field, f.Before, f.After)
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// DriftDetector 定期采集策略快照并与基线比较
+type DriftDetector struct {
+	AC        *AC
+	Groups    []string           // 需要比较关联策略的组
+	Users     []string           // 需要比较关联策略的用户
+	StatePath string             // 基线快照文件,为空时只保存在内存中
+	OnDrift   func(*DriftReport) // 存在变更时回调
+
+	baseline *PolicySnapshot
+}
+
+// Check 采集快照并与基线比较,比较后以本次快照作为新的基线
+// 首次采集(无基线)时返回 Baseline 为true的空报告
+func (d *DriftDetector) Check() (*DriftReport, error) {
+	if d.AC == nil {
+		return nil, acErrArg()
+	}
+	if d.baseline == nil && d.StatePath != "" {
+		var s PolicySnapshot
+		ok, err := acLoadJSON(d.StatePath, &s)
+		if err != nil {
+			return nil, fmt.Errorf("load baseline: %w", err)
+		}
+		if ok {
+			d.baseline = &s
+		}
+	}
+	snap, err := d.AC.CapturePolicies(d.Groups, d.Users)
+	if err != nil {
+		return nil, err
+	}
+	var report *DriftReport
+	if d.baseline == nil {
+		report = &DriftReport{From: snap.Time, To: snap.Time, Baseline: true}
+	} else {
+		report = DiffPolicies(d.baseline, snap)
+	}
+	if d.StatePath != "" {
+		if err = acSaveJSON(d.StatePath, snap); err != nil {
+			return report, fmt.Errorf("save baseline: %w", err)
+		}
+	}
+	d.baseline = snap
+	if len(report.Changes) > 0 && d.OnDrift != nil {
+		d.OnDrift(report)
+	}
+	return report, nil
+}
+
+// Run 按interval定期检查,直到ctx结束
+func (d *DriftDetector) Run(ctx context.Context, interval time.Duration, onErr func(error)) error {
+	if interval <= 0 {
+		return acErrArg()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := d.Check(); err != nil && onErr != nil {
+			onErr(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// acDiffFields 按json字段名比较两个同类型结构体,sets为true时[]string字段按集合比较
+func acDiffFields(prefix string, before, after interface{}, sets bool) []FieldChange {
+	var (
+		r  []FieldChange
+		bv = reflect.ValueOf(before)
+		av = reflect.ValueOf(after)
+		t  = bv.Type()
+	)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			name = f.Name
+		}
+		b, a := bv.Field(i).Interface(), av.Field(i).Interface()
+		if bs, ok := b.([]string); ok && sets {
+			added, removed := acDiffList(bs, a.([]string))
+			if len(added) > 0 || len(removed) > 0 {
+				r = append(r, FieldChange{Field: prefix + name, Added: added, Removed: removed})
+			}
+			continue
+		}
+		if !reflect.DeepEqual(b, a) {
+			r = append(r, FieldChange{Field: prefix + name, Before: b, After: a})
+		}
+	}
+	return r
+}
+
+// acDiffList 返回after相对before增加和删除的项
+func acDiffList(before, after []string) (added, removed []string) {
+	bs := make(map[string]bool, len(before))
+	for _, v := range before {
+		bs[v] = true
+	}
+	as := make(map[string]bool, len(after))
+	for _, v := range after {
+		as[v] = true
+		if !bs[v] {
+			added = append(added, v)
+		}
+	}
+	for _, v := range before {
+		if !as[v] {
+			removed = append(removed, v)
+		}
+	}
+	return added, removed
+}
+
+// acUnionKeys 返回两个map的key并集(排序)
+func acUnionKeys(a, b interface{}) []string {
+	seen := make(map[string]bool)
+	for _, m := range []reflect.Value{reflect.ValueOf(a), reflect.ValueOf(b)} {
+		for _, k := range m.MapKeys() {
+			seen[k.String()] = true
+		}
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
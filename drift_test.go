@@ -0,0 +1,115 @@
+package sangfor
+
+import (
+	"bytes"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSnapshot() *PolicySnapshot {
+	var guest NetPolicy
+	guest.PolicyInfo = NetPolicyInfo{Name: "guest", Status: true}
+	guest.UserInfo.Ou = []string{"/guest", "/visitor"}
+	return &PolicySnapshot{
+		Time: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		Net: map[string]NetPolicy{
+			"guest": guest,
+			"old":   {PolicyInfo: NetPolicyInfo{Name: "old"}},
+		},
+		Flux: map[string]FluxPolicy{
+			"1": {Id: "1", Name: "线路1", Max: []string{"100mbps", "100mbps"}},
+		},
+		Groups: map[string][]string{"/dev": {"guest", "old"}},
+		Users:  map[string][]string{"alice": {"guest"}},
+	}
+}
+
+func TestDiffPolicies(t *testing.T) {
+	before, after := testSnapshot(), testSnapshot()
+	after.Time = before.Time.Add(time.Hour)
+	delete(after.Net, "old")
+	after.Net["new"] = NetPolicy{PolicyInfo: NetPolicyInfo{Name: "new"}}
+	guest := after.Net["guest"]
+	guest.PolicyInfo.Status = false
+	guest.UserInfo.Ou = []string{"/visitor", "/staff"}
+	after.Net["guest"] = guest
+	after.Flux["1"] = FluxPolicy{Id: "1", Name: "线路1", Max: []string{"100mbps", "50mbps"}}
+	after.Groups["/dev"] = []string{"old", "guest"} // 顺序变化不算变更
+	after.Users["alice"] = []string{"new"}
+	after.Users["bob"] = []string{"guest"} // 基线中未采集,不比较
+
+	r := DiffPolicies(before, after)
+	want := []PolicyChange{
+		{Kind: "net", Name: "guest", Action: DriftChanged, Fields: []FieldChange{
+			{Field: "status", Before: true, After: false},
+			{Field: "user_info.ou", Added: []string{"/staff"}, Removed: []string{"/guest"}},
+		}},
+		{Kind: "net", Name: "new", Action: DriftAdded},
+		{Kind: "net", Name: "old", Action: DriftRemoved},
+		{Kind: "flux", Name: "线路1", Action: DriftChanged, Fields: []FieldChange{
+			{Field: "max", Before: []string{"100mbps", "100mbps"}, After: []string{"100mbps", "50mbps"}},
+		}},
+		{Kind: "user", Name: "alice", Action: DriftChanged, Fields: []FieldChange{
+			{Field: "policy", Added: []string{"new"}, Removed: []string{"guest"}},
+		}},
+	}
+	if !reflect.DeepEqual(r.Changes, want) {
+		t.Fatalf("changes:\n got %+v\nwant %+v", r.Changes, want)
+	}
+	if !r.From.Equal(before.Time) || !r.To.Equal(after.Time) {
+		t.Fatalf("report time = %v - %v", r.From, r.To)
+	}
+	if r := DiffPolicies(before, testSnapshot()); len(r.Changes) != 0 {
+		t.Fatalf("identical snapshots: changes = %+v", r.Changes)
+	}
+
+	var b bytes.Buffer
+	if err := DiffPolicies(before, after).WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"[net] guest changed", "  status: true -> false", "  user_info.ou: +[/staff] -[/guest]", "[net] old removed"} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Fatalf("missing %q in\n%s", line, b.String())
+		}
+	}
+}
+
+// TestDriftDetector 首次检查建立基线,基线持久化后新的检测器可继续比较
+func TestDriftDetector(t *testing.T) {
+	status := true
+	ac, _ := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		if endpoint == acNetPolicy {
+			return []interface{}{
+				map[string]interface{}{"policy_info": map[string]interface{}{"name": "guest", "status": status}},
+			}, nil
+		}
+		return []interface{}{}, nil
+	})
+	var (
+		path    = filepath.Join(t.TempDir(), "baseline.json")
+		drifted []*DriftReport
+		d       = &DriftDetector{AC: ac, StatePath: path, OnDrift: func(r *DriftReport) { drifted = append(drifted, r) }}
+	)
+	r, err := d.Check()
+	if err != nil || !r.Baseline || len(r.Changes) != 0 {
+		t.Fatalf("first check = %+v, %v, want baseline", r, err)
+	}
+	if r, err = d.Check(); err != nil || r.Baseline || len(r.Changes) != 0 || len(drifted) != 0 {
+		t.Fatalf("unchanged check = %+v, %v, drifted = %d", r, err, len(drifted))
+	}
+
+	status = false
+	d = &DriftDetector{AC: ac, StatePath: path, OnDrift: d.OnDrift}
+	r, err = d.Check()
+	if err != nil || r.Baseline || len(r.Changes) != 1 || r.Changes[0].Name != "guest" || len(drifted) != 1 {
+		t.Fatalf("check after reload = %+v, %v, drifted = %d", r, err, len(drifted))
+	}
+	// 变更后的快照成为新的基线
+	if r, err = d.Check(); err != nil || len(r.Changes) != 0 || len(drifted) != 1 {
+		t.Fatalf("check after drift = %+v, %v, drifted = %d", r, err, len(drifted))
+	}
+}
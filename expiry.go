@@ -0,0 +1,320 @@
+/**
+ * @Description: sangfor ac expiring accounts scanner
+ * @File:  expiry
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ExpiringUser 即将过期的账号
+type ExpiringUser struct {
+	Name        string    `json:"name"`                    // 用户名
+	ShowName    string    `json:"show_name,omitempty"`     // 显示名
+	FatherPath  string    `json:"father_path,omitempty"`   // 所在组
+	ExpireAt    time.Time `json:"expire_at"`               // 过期时间(已扣除设备时钟偏移)
+	ExpireDate  string    `json:"expire_date"`             // 设备记录的过期日期
+	Remaining   string    `json:"remaining"`               // 剩余时长
+	Extended    bool      `json:"extended,omitempty"`      // 是否已自动续期
+	NewExpireAt time.Time `json:"new_expire_at,omitempty"` // 续期后的过期时间
+	ExtendError string    `json:"extend_error,omitempty"`  // 续期失败原因
+}
+
+// ExpiryReport 过期扫描报告
+type ExpiryReport struct {
+	Target      string         `json:"target"`           // 设备地址
+	GeneratedAt time.Time      `json:"generated_at"`     // 报告生成时间
+	WindowEnd   time.Time      `json:"window_end"`       // 扫描窗口截止时间
+	Users       []ExpiringUser `json:"users"`            // 窗口内过期的账号(按过期时间排序)
+	Errors      []string       `json:"errors,omitempty"` // 扫描过程中的错误(组搜索失败,时间解析失败等)
+}
+
+// WriteText 输出文本格式报告
+func (r *ExpiryReport) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "AC %s 账号过期报告 %s (截止 %s)\n", r.Target,
+		r.GeneratedAt.Format(acTimeLayout), r.WindowEnd.Format(acTimeLayout))
+	if len(r.Users) == 0 {
+		b.WriteString("窗口内无即将过期的账号\n")
+	}
+	for _, u := range r.Users {
+		fmt.Fprintf(&b, "%-20s %-20s %s 剩余%s", u.Name, u.FatherPath, u.ExpireAt.Format(acTimeLayout), u.Remaining)
+		if u.Extended {
+			fmt.Fprintf(&b, " 已续期至%s", u.NewExpireAt.Format(acTimeLayout))
+		}
+		if u.ExtendError != "" {
+			fmt.Fprintf(&b, " 续期失败:%s", u.ExtendError)
+		}
+		b.WriteString("\n")
+	}
+	for _, e := range r.Errors {
+		fmt.Fprintf(&b, "错误: %s\n", e)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ExpiryNotifier 过期报告通知
+type ExpiryNotifier interface {
+	NotifyExpiry(report *ExpiryReport) error
+}
+
+// ExpiryNotifierFunc 函数形式的过期报告通知
+type ExpiryNotifierFunc func(report *ExpiryReport) error
+
+func (f ExpiryNotifierFunc) NotifyExpiry(report *ExpiryReport) error {
+	return f(report)
+}
+
+// ExpiryScanner 账号过期扫描器
+// 按组枚举窗口内过期的账号,生成报告并通知,白名单中的账号可自动通过 UserMod 续期
+type ExpiryScanner struct {
+	AC        *AC
+	Groups    []string         // 扫描的组(以"/"开头),为空时扫描"/"
+	Window    time.Duration    // 扫描窗口,即从当前时间起多久内过期,为0时为7天
+	Notifiers []ExpiryNotifier // 报告通知,窗口内无过期账号时不通知
+	Allowlist []string         // 自动续期白名单(用户名)
+	ExtendBy  time.Duration    // 自动续期时长(从原过期时间起算,续期后仍在窗口内时按整数倍续期),为0时不续期
+}
+
+// Scan 扫描一次并发送通知
+// AC搜索接口每次最多返回100个用户,用户较多时请按组拆分 Groups
+func (s *ExpiryScanner) Scan() (*ExpiryReport, error) {
+	if s.AC == nil {
+		return nil, acErrArg()
+	}
+	var (
+		ac     = s.AC
+		now    = time.Now()
+		window = s.Window
+		groups = s.Groups
+		seen   = make(map[string]bool)
+		failed int
+	)
+	if window <= 0 {
+		window = 7 * 24 * time.Hour
+	}
+	if len(groups) == 0 {
+		groups = []string{"/"}
+	}
+	report := &ExpiryReport{Target: ac.target(), GeneratedAt: now, WindowEnd: now.Add(window)}
+	for _, group := range groups {
+		// 设备只记录过期日期,搜索窗口向前放宽一天,再由本地精确过滤
+		users, err := ac.UserSearch(SearchByName("", InGroup(group),
+			ac.ExpiringBetween(now.AddDate(0, 0, -1), report.WindowEnd)))
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("search group %s: %v", group, err))
+			failed++
+			continue
+		}
+		for i := range users {
+			u := &users[i]
+			if seen[u.Name] {
+				continue
+			}
+			seen[u.Name] = true
+			expire, ok, err := ac.UserExpireTime(u)
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("user %s: %v", u.Name, err))
+				continue
+			}
+			if !ok || expire.Before(now) || expire.After(report.WindowEnd) {
+				continue
+			}
+			report.Users = append(report.Users, ExpiringUser{
+				Name:       u.Name,
+				ShowName:   u.ShowName,
+				FatherPath: u.FatherPath,
+				ExpireAt:   expire,
+				ExpireDate: u.ExpireTime.Date,
+				Remaining:  expire.Sub(now).Truncate(time.Minute).String(),
+			})
+		}
+	}
+	if failed == len(groups) {
+		return nil, errors.New(strings.Join(report.Errors, "; "))
+	}
+	sort.Slice(report.Users, func(i, j int) bool {
+		return report.Users[i].ExpireAt.Before(report.Users[j].ExpireAt)
+	})
+	s.extend(report)
+	if len(report.Users) == 0 {
+		return report, nil
+	}
+	var errs []string
+	for _, n := range s.Notifiers {
+		if err := n.NotifyExpiry(report); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return report, fmt.Errorf("notify expiry report: %s", strings.Join(errs, "; "))
+	}
+	return report, nil
+}
+
+// extend 对白名单中的账号自动续期
+func (s *ExpiryScanner) extend(report *ExpiryReport) {
+	if s.ExtendBy <= 0 || len(s.Allowlist) == 0 {
+		return
+	}
+	allow := make(map[string]bool, len(s.Allowlist))
+	for _, name := range s.Allowlist {
+		allow[name] = true
+	}
+	for i := range report.Users {
+		u := &report.Users[i]
+		if !allow[u.Name] {
+			continue
+		}
+		newExpire, value, err := s.extendTo(u, report.WindowEnd)
+		if err != nil {
+			u.ExtendError = err.Error()
+			continue
+		}
+		var mod = UserMod{Name: u.Name}
+		mod.Data.ExpireTime = value
+		if _, err := s.AC.UserMod(mod); err != nil {
+			u.ExtendError = err.Error()
+			continue
+		}
+		u.Extended, u.NewExpireAt = true, newExpire
+	}
+}
+
+// extendTo 计算续期后的过期时间及设置到设备的值
+// 续期后仍在扫描窗口内时按 ExtendBy 的整数倍续期至窗口之后,避免每次扫描重复续期
+// 设备只记录日期时(当天结束时过期),按设备日期续期,不足一天的部分按一天计
+func (s *ExpiryScanner) extendTo(u *ExpiringUser, windowEnd time.Time) (time.Time, string, error) {
+	if len(u.ExpireDate) != len(acDateLayout) {
+		n := windowEnd.Sub(u.ExpireAt)/s.ExtendBy + 1
+		newExpire := u.ExpireAt.Add(n * s.ExtendBy)
+		return newExpire, s.AC.FormatDeviceTime(newExpire), nil
+	}
+	date, err := time.ParseInLocation(acDateLayout, u.ExpireDate, s.AC.location())
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	days := int((s.ExtendBy + 24*time.Hour - 1) / (24 * time.Hour))
+	for {
+		date = date.AddDate(0, 0, days)
+		value := date.Format(acDateLayout)
+		newExpire, err := s.AC.ParseDeviceTime(value)
+		if err != nil {
+			return time.Time{}, "", err
+		}
+		if newExpire = newExpire.AddDate(0, 0, 1); newExpire.After(windowEnd) {
+			return newExpire, value, nil
+		}
+	}
+}
+
+// Run 按间隔周期扫描,直到ctx结束,单次扫描的错误交由onErr处理(可为nil)
+func (s *ExpiryScanner) Run(ctx context.Context, interval time.Duration, onErr func(error)) error {
+	if interval <= 0 {
+		return acErrArg()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Scan(); err != nil && onErr != nil {
+			onErr(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// WebhookNotifier 以JSON格式POST报告到webhook地址
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client // 为空时使用20秒超时的默认客户端
+}
+
+func (n *WebhookNotifier) NotifyExpiry(report *ExpiryReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	client := n.Client
+	if client == nil {
+		client = &http.Client{Timeout: 20 * time.Second}
+	}
+	resp, err := client.Post(n.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s: %s", n.URL, resp.Status)
+	}
+	return nil
+}
+
+// MailNotifier 通过SMTP发送文本格式报告
+type MailNotifier struct {
+	Addr    string    // SMTP服务地址(host:port)
+	Auth    smtp.Auth // 认证信息,可为nil
+	From    string
+	To      []string
+	Subject string // 邮件标题,为空时使用默认标题
+	// SendMail 实际发送函数,为空时使用 smtp.SendMail,可替换为测试桩或其他邮件通道
+	SendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func (n *MailNotifier) NotifyExpiry(report *ExpiryReport) error {
+	subject := n.Subject
+	if subject == "" {
+		subject = fmt.Sprintf("AC %s: %d个账号即将过期", report.Target, len(report.Users))
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\n", n.From, strings.Join(n.To, ", "), mime.QEncoding.Encode("utf-8", subject))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n")
+	if err := report.WriteText(&msg); err != nil {
+		return err
+	}
+	send := n.SendMail
+	if send == nil {
+		send = smtp.SendMail
+	}
+	return send(n.Addr, n.Auth, n.From, n.To, msg.Bytes())
+}
+
+// FileNotifier 以JSON行格式追加报告到文件
+type FileNotifier struct {
+	Path string
+}
+
+func (n *FileNotifier) NotifyExpiry(report *ExpiryReport) error {
+	line, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(n.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,163 @@
+package sangfor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// expiryDevice 模拟按组搜索用户及修改过期时间,mods记录 UserMod 设置的过期时间
+func expiryDevice(t *testing.T, users map[string][]map[string]interface{}, mods map[string]string) *AC {
+	ac, _ := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		switch r.URL.Query().Get("_method") {
+		case "GET":
+			group := body["extend"].(map[string]interface{})["father_path"].(string)
+			list, ok := users[group]
+			if !ok {
+				return nil, errors.New("group not found")
+			}
+			return list, nil
+		case "PUT":
+			mods[body["name"].(string)] = body["data"].(map[string]interface{})["expire_time"].(string)
+			return "ok", nil
+		}
+		return nil, errors.New("unexpected request")
+	})
+	return ac
+}
+
+func acTestUser(name, expire string) map[string]interface{} {
+	return map[string]interface{}{"name": name, "expire_time": map[string]interface{}{"enable": true, "date": expire}}
+}
+
+func TestExpiryScanExtend(t *testing.T) {
+	var (
+		today    = time.Now()
+		date     = today.AddDate(0, 0, 2).Format(acDateLayout)
+		datetime = today.Add(48 * time.Hour).Truncate(time.Second)
+		mods     = make(map[string]string)
+	)
+	ac := expiryDevice(t, map[string][]map[string]interface{}{
+		"/": {acTestUser("date", date), acTestUser("datetime", datetime.Format(acTimeLayout)), acTestUser("later", today.AddDate(0, 1, 0).Format(acDateLayout))},
+	}, mods)
+	s := &ExpiryScanner{AC: ac, Allowlist: []string{"date", "datetime"}, ExtendBy: 7 * 24 * time.Hour}
+	report, err := s.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Users) != 2 {
+		t.Fatalf("users = %+v, want date and datetime", report.Users)
+	}
+	wantDate := today.AddDate(0, 0, 9).Format(acDateLayout)
+	wantTime := datetime.Add(7 * 24 * time.Hour).Format(acTimeLayout)
+	if mods["date"] != wantDate || mods["datetime"] != wantTime {
+		t.Fatalf("mods = %v, want date=%s datetime=%s", mods, wantDate, wantTime)
+	}
+	for _, u := range report.Users {
+		if want := u.ExpireAt.Add(s.ExtendBy); !u.Extended || !u.NewExpireAt.Equal(want) {
+			t.Fatalf("%s extended to %v, want %v", u.Name, u.NewExpireAt, want)
+		}
+	}
+}
+
+func TestExpiryScanErrors(t *testing.T) {
+	mods := make(map[string]string)
+	ac := expiryDevice(t, map[string][]map[string]interface{}{
+		"/ok": {acTestUser("bad", "not a date")},
+	}, mods)
+	cases := []struct {
+		name   string
+		groups []string
+		err    bool
+		errors int
+	}{
+		{"one group and one user failed", []string{"/missing", "/ok"}, false, 2},
+		{"all groups failed", []string{"/missing", "/gone"}, true, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			report, err := (&ExpiryScanner{AC: ac, Groups: c.groups}).Scan()
+			if (err != nil) != c.err {
+				t.Fatalf("err = %v, want error %v", err, c.err)
+			}
+			if !c.err && len(report.Errors) != c.errors {
+				t.Fatalf("errors = %v, want %d", report.Errors, c.errors)
+			}
+		})
+	}
+}
+
+func TestMailNotifierSubject(t *testing.T) {
+	var sent string
+	n := &MailNotifier{From: "ac@example.com", To: []string{"ops@example.com"},
+		SendMail: func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			sent = string(msg)
+			return nil
+		}}
+	if err := n.NotifyExpiry(&ExpiryReport{Target: "ac1", Users: []ExpiringUser{{Name: "a"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sent, "Subject: =?utf-8?q?") {
+		t.Fatalf("subject is not encoded: %q", sent)
+	}
+}
+
+// TestExpiryExtendShort 只记录日期的账号续期不足一天时按一天计,续期后仍在窗口内时续期至窗口之后
+func TestExpiryExtendShort(t *testing.T) {
+	var (
+		today = time.Now()
+		mods  = make(map[string]string)
+	)
+	ac := expiryDevice(t, map[string][]map[string]interface{}{
+		"/": {acTestUser("date", today.AddDate(0, 0, 2).Format(acDateLayout))},
+	}, mods)
+	s := &ExpiryScanner{AC: ac, Window: 3 * 24 * time.Hour, Allowlist: []string{"date"}, ExtendBy: time.Hour}
+	report, err := s.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := today.AddDate(0, 0, 3).Format(acDateLayout); mods["date"] != want {
+		t.Fatalf("extended to %q, want %s", mods["date"], want)
+	}
+	if u := report.Users[0]; !u.Extended || !u.NewExpireAt.After(report.WindowEnd) {
+		t.Fatalf("user = %+v, want extended past %v", u, report.WindowEnd)
+	}
+}
+
+// TestExpiryRunExtendOnce 周期扫描时已续期至窗口之后的账号不再重复续期
+func TestExpiryRunExtendOnce(t *testing.T) {
+	var (
+		today  = time.Now()
+		expire = today.AddDate(0, 0, 2).Format(acDateLayout)
+		puts   int
+	)
+	ac, _ := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Query().Get("_method") == "PUT" {
+			puts++
+			expire = body["data"].(map[string]interface{})["expire_time"].(string)
+			return "ok", nil
+		}
+		return []interface{}{acTestUser("date", expire)}, nil
+	})
+	s := &ExpiryScanner{AC: ac, Allowlist: []string{"date"}, ExtendBy: 24 * time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := s.Run(ctx, 10*time.Millisecond, func(err error) { t.Error(err) }); err != context.DeadlineExceeded {
+		t.Fatalf("Run err = %v", err)
+	}
+	if want := today.AddDate(0, 0, 7).Format(acDateLayout); puts != 1 || expire != want {
+		t.Fatalf("extended %d times to %s, want once to %s", puts, expire, want)
+	}
+	if err := s.Run(ctx, 0, nil); err == nil {
+		t.Fatal("want error for zero interval")
+	}
+}
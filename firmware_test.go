@@ -0,0 +1,164 @@
+package sangfor
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseFirmwareVersion(t *testing.T) {
+	cases := []struct {
+		in      string
+		product string
+		parts   []int
+		build   string
+		err     bool
+	}{
+		{"AC13.0.15.097", "AC", []int{13, 0, 15, 97}, "", false},
+		{"AC13.0.15.097 Build20210304", "AC", []int{13, 0, 15, 97}, "20210304", false},
+		{" ac v12.0.42 build 20190101 R1", "AC", []int{12, 0, 42}, "20190101", false},
+		{"13.0", "", []int{13, 0}, "", false},
+		{"unknown", "", nil, "", true},
+		{"", "", nil, "", true},
+	}
+	for _, c := range cases {
+		v, err := ParseFirmwareVersion(c.in)
+		if (err != nil) != c.err {
+			t.Fatalf("ParseFirmwareVersion(%q) err = %v, want error %v", c.in, err, c.err)
+		}
+		if c.err {
+			continue
+		}
+		if v.Product != c.product || v.Build != c.build || len(v.Parts) != len(c.parts) {
+			t.Fatalf("ParseFirmwareVersion(%q) = %+v", c.in, v)
+		}
+		for i := range c.parts {
+			if v.Parts[i] != c.parts[i] {
+				t.Fatalf("ParseFirmwareVersion(%q) parts = %v, want %v", c.in, v.Parts, c.parts)
+			}
+		}
+	}
+}
+
+func TestFirmwareCompare(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"AC13.0.15.097", "AC13.0.15.097", 0},
+		{"AC13.0", "AC13.0.0", 0},
+		{"AC12.0.42", "AC13.0", -1},
+		{"AC13.0.15.100", "AC13.0.15.97", 1},
+		{"AC13.0 Build20210304", "AC13.0 Build20200101", 1},
+		{"AC13.0 Build20210304", "AC13.0", 0},
+	}
+	for _, c := range cases {
+		a, _ := ParseFirmwareVersion(c.a)
+		b, _ := ParseFirmwareVersion(c.b)
+		if got := a.Compare(b); got != c.want {
+			t.Fatalf("%s vs %s = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+	v, _ := ParseFirmwareVersion("AC13.0.15.097")
+	if !v.AtLeast("AC13.0") || v.AtLeast("AC13.1") || v.AtLeast("bad") {
+		t.Fatal("AtLeast mismatch")
+	}
+}
+
+// TestFirmwareBackoff 检测失败的结果在重试间隔内直接返回,成功后一直缓存
+func TestFirmwareBackoff(t *testing.T) {
+	fail := true
+	ac, d := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		if fail {
+			return nil, errors.New("busy")
+		}
+		return "AC13.0.15.097", nil
+	})
+	if _, err := ac.Firmware(); err == nil || len(d.calls) != 1 {
+		t.Fatalf("err = %v, calls = %d, want detection error", err, len(d.calls))
+	}
+	if _, err := ac.Firmware(); err == nil || err.Error() != "busy" || len(d.calls) != 1 {
+		t.Fatalf("err = %v, calls = %d, want cached error", err, len(d.calls))
+	}
+	expire := func() {
+		ac.firmware.mu.Lock()
+		ac.firmware.retryAt = time.Time{}
+		ac.firmware.mu.Unlock()
+	}
+	expire()
+	if _, err := ac.Firmware(); err == nil || len(d.calls) != 2 || ac.firmware.backoff != 4*acFirmwareRetryMin {
+		t.Fatalf("err = %v, calls = %d, backoff = %v, want retry with doubled backoff", err, len(d.calls), ac.firmware.backoff)
+	}
+	for i := 0; i < 10; i++ {
+		expire()
+		ac.Firmware()
+	}
+	if ac.firmware.backoff != acFirmwareRetryMax {
+		t.Fatalf("backoff = %v, want capped at %v", ac.firmware.backoff, acFirmwareRetryMax)
+	}
+
+	fail = false
+	expire()
+	v, err := ac.Firmware()
+	if err != nil || v.String() != "AC13.0.15.097" {
+		t.Fatalf("Firmware = %v, %v", v, err)
+	}
+	calls := len(d.calls)
+	if _, err = ac.Firmware(); err != nil || len(d.calls) != calls {
+		t.Fatal("successful detection should be cached")
+	}
+	// SetFirmware("")清除缓存及失败状态
+	fail = true
+	ac.Firmware()
+	if err = ac.SetFirmware(""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ac.Firmware(); err == nil || len(d.calls) != calls+1 {
+		t.Fatalf("err = %v, calls = %d, want a new detection", err, len(d.calls))
+	}
+}
+
+// TestRequireMatrix 没有版本限制的功能不检测版本,有限制时按版本返回 ErrUnsupported
+func TestRequireMatrix(t *testing.T) {
+	ac, d := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		return "AC13.0.15.097", nil
+	})
+	if !ac.Supports(CapBindUser) || ac.adapter() != acAdapters[0] || len(d.calls) != 0 {
+		t.Fatalf("calls = %v, want no detection", d.calls)
+	}
+
+	acCapabilityMatrix[CapBindUser] = acFirmwareRange{since: "AC13.1"}
+	defer delete(acCapabilityMatrix, CapBindUser)
+	if ac.Supports(CapBindUser) || len(d.calls) != 1 {
+		t.Fatalf("calls = %v, want CapBindUser unsupported on AC13.0.15.097", d.calls)
+	}
+	err := ac.require(CapBindUser)
+	if !errors.Is(err, ErrUnsupported) || err.Error() != "operation not supported: bind_user requires firmware >=AC13.1, device is AC13.0.15.097" {
+		t.Fatalf("err = %v", err)
+	}
+	if _, err = ac.BindUserDel("10.0.0.1"); !errors.Is(err, ErrUnsupported) || len(d.calls) != 1 {
+		t.Fatalf("err = %v, calls = %v, want no request", err, d.calls)
+	}
+	if err = ac.SetFirmware("AC13.1.0"); err != nil || !ac.Supports(CapBindUser) {
+		t.Fatalf("err = %v, want CapBindUser supported on AC13.1.0", err)
+	}
+}
+
+func TestFirmwareRange(t *testing.T) {
+	v, _ := ParseFirmwareVersion("AC13.0.15.097")
+	cases := []struct {
+		r        acFirmwareRange
+		contains bool
+		str      string
+	}{
+		{acFirmwareRange{since: "AC13.0"}, true, ">=AC13.0"},
+		{acFirmwareRange{until: "AC13.0.15.097"}, false, "<AC13.0.15.097"},
+		{acFirmwareRange{since: "AC12", until: "AC14"}, true, ">=AC12,<AC14"},
+	}
+	for _, c := range cases {
+		if c.r.contains(v) != c.contains || c.r.String() != c.str {
+			t.Fatalf("%s contains = %v, want %v", c.r, c.r.contains(v), c.contains)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+/**
+ * @Description: multi-device fleet with concurrent fan-out
+ * @File:  fleet
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FleetMember 设备组中的单台设备
+type FleetMember struct {
+	Name string
+	AC   *AC
+	Tags map[string]string // 标签,e.g:region=华东,role=branch
+}
+
+// FleetSelector 设备选择条件
+type FleetSelector func(m *FleetMember) bool
+
+// FleetWithTag 选择标签key的值为values之一的设备
+func FleetWithTag(key string, values ...string) FleetSelector {
+	return func(m *FleetMember) bool {
+		v, ok := m.Tags[key]
+		if !ok {
+			return false
+		}
+		if len(values) == 0 {
+			return true
+		}
+		for _, want := range values {
+			if v == want {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// FleetNamed 按设备名选择
+func FleetNamed(names ...string) FleetSelector {
+	return func(m *FleetMember) bool {
+		for _, n := range names {
+			if m.Name == n {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Fleet 多台AC设备,可并发地在所有(或选中的)设备上执行操作
+type Fleet struct {
+	Concurrency int // 最大并发数,为0时不限制
+
+	mu      sync.RWMutex
+	members []*FleetMember
+}
+
+// NewFleet 创建设备组
+func NewFleet() *Fleet {
+	return &Fleet{}
+}
+
+// Add 添加设备,设备名不能重复
+func (f *Fleet) Add(name string, ac *AC, tags map[string]string) error {
+	if name == "" || ac == nil {
+		return acErrArg()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, m := range f.members {
+		if m.Name == name {
+			return fmt.Errorf("device %s already exists", name)
+		}
+	}
+	f.members = append(f.members, &FleetMember{Name: name, AC: ac, Tags: tags})
+	return nil
+}
+
+// Remove 移除设备
+func (f *Fleet) Remove(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, m := range f.members {
+		if m.Name == name {
+			f.members = append(f.members[:i:i], f.members[i+1:]...)
+			return
+		}
+	}
+}
+
+// Get 按名称获取设备
+func (f *Fleet) Get(name string) *AC {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, m := range f.members {
+		if m.Name == name {
+			return m.AC
+		}
+	}
+	return nil
+}
+
+// Members 返回所有设备
+func (f *Fleet) Members() []*FleetMember {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return append([]*FleetMember(nil), f.members...)
+}
+
+// Select 返回满足所有条件的设备组成的子设备组(与原设备组共享*AC)
+func (f *Fleet) Select(selectors ...FleetSelector) *Fleet {
+	sub := &Fleet{Concurrency: f.Concurrency}
+	for _, m := range f.Members() {
+		ok := true
+		for _, s := range selectors {
+			if !s(m) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			sub.members = append(sub.members, m)
+		}
+	}
+	return sub
+}
+
+// FleetResult 单台设备的执行结果
+type FleetResult struct {
+	Device   string        `json:"device"`
+	Value    interface{}   `json:"value,omitempty"`
+	Err      error         `json:"-"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// FleetResults 按设备添加顺序排列的执行结果
+type FleetResults []FleetResult
+
+// Failed 返回执行失败的结果
+func (rs FleetResults) Failed() FleetResults {
+	var r FleetResults
+	for _, res := range rs {
+		if res.Err != nil {
+			r = append(r, res)
+		}
+	}
+	return r
+}
+
+// Values 返回执行成功的设备及结果
+func (rs FleetResults) Values() map[string]interface{} {
+	r := make(map[string]interface{})
+	for _, res := range rs {
+		if res.Err == nil {
+			r[res.Device] = res.Value
+		}
+	}
+	return r
+}
+
+// Err 汇总失败设备的错误,全部成功时返回nil
+func (rs FleetResults) Err() error {
+	failed := rs.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(failed))
+	for _, res := range failed {
+		msgs = append(msgs, res.Device+": "+res.Err.Error())
+	}
+	sort.Strings(msgs)
+	return fmt.Errorf("%d of %d devices failed: %s", len(failed), len(rs), strings.Join(msgs, "; "))
+}
+
+// Do 在所有设备上并发执行fn,传入的*AC已绑定ctx,fn中的panic作为该设备的错误返回
+func (f *Fleet) Do(ctx context.Context, fn func(name string, ac *AC) (interface{}, error)) FleetResults {
+	var (
+		members = f.Members()
+		results = make(FleetResults, len(members))
+		wg      sync.WaitGroup
+		sem     chan struct{}
+	)
+	if f.Concurrency > 0 {
+		sem = make(chan struct{}, f.Concurrency)
+	}
+	for i, m := range members {
+		wg.Add(1)
+		go func(i int, m *FleetMember) {
+			defer wg.Done()
+			results[i].Device = m.Name
+			if sem != nil {
+				select {
+				case sem <- struct{}{}:
+					defer func() { <-sem }()
+				case <-ctx.Done():
+				}
+			}
+			if err := ctx.Err(); err != nil { // ctx已结束(包括等待槽位期间)时不再执行
+				results[i].Err, results[i].Error = err, err.Error()
+				return
+			}
+			start := time.Now()
+			v, err := acFleetCall(fn, m.Name, m.AC.WithContext(ctx))
+			results[i].Value, results[i].Err, results[i].Duration = v, err, time.Since(start)
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}(i, m)
+	}
+	wg.Wait()
+	return results
+}
+
+// acFleetCall 执行fn,将panic转换为错误,避免单台设备的异常导致进程退出
+func acFleetCall(fn func(name string, ac *AC) (interface{}, error), name string, ac *AC) (v interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			v, err = nil, fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(name, ac)
+}
+
+// GetVersion 获取所有设备的版本信息
+func (f *Fleet) GetVersion(ctx context.Context) FleetResults {
+	return f.Do(ctx, func(name string, ac *AC) (interface{}, error) { return ac.GetVersion() })
+}
+
+// OnlineUserKick 在所有设备上注销指定IP的在线用户
+func (f *Fleet) OnlineUserKick(ctx context.Context, ip string) FleetResults {
+	return f.Do(ctx, func(name string, ac *AC) (interface{}, error) { return nil, ac.OnlineUserKick(ip) })
+}
+
+// UserAdd 在所有设备上添加用户
+func (f *Fleet) UserAdd(ctx context.Context, data UserAdd) FleetResults {
+	return f.Do(ctx, func(name string, ac *AC) (interface{}, error) { return ac.UserAdd(data) })
+}
+
+// UserDel 在所有设备上删除用户
+func (f *Fleet) UserDel(ctx context.Context, username string) FleetResults {
+	return f.Do(ctx, func(name string, ac *AC) (interface{}, error) { return ac.UserDel(username) })
+}
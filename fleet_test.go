@@ -0,0 +1,162 @@
+package sangfor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testFleet(t *testing.T) *Fleet {
+	f := NewFleet()
+	for _, m := range []struct {
+		name   string
+		region string
+		role   string
+	}{
+		{"sh-1", "华东", "branch"},
+		{"sh-2", "华东", "hq"},
+		{"bj-1", "华北", "branch"},
+		{"gz-1", "华南", ""},
+	} {
+		tags := map[string]string{"region": m.region}
+		if m.role != "" {
+			tags["role"] = m.role
+		}
+		if err := f.Add(m.name, NewAC("127.0.0.1:1", "secret"), tags); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+func acFleetNames(f *Fleet) []string {
+	var r []string
+	for _, m := range f.Members() {
+		r = append(r, m.Name)
+	}
+	return r
+}
+
+func TestFleetSelect(t *testing.T) {
+	f := testFleet(t)
+	cases := []struct {
+		name      string
+		selectors []FleetSelector
+		want      []string
+	}{
+		{"all", nil, []string{"sh-1", "sh-2", "bj-1", "gz-1"}},
+		{"tag values", []FleetSelector{FleetWithTag("region", "华东", "华北")}, []string{"sh-1", "sh-2", "bj-1"}},
+		{"tag present", []FleetSelector{FleetWithTag("role")}, []string{"sh-1", "sh-2", "bj-1"}},
+		{"and", []FleetSelector{FleetWithTag("region", "华东"), FleetWithTag("role", "branch")}, []string{"sh-1"}},
+		{"named", []FleetSelector{FleetNamed("gz-1", "bj-1")}, []string{"bj-1", "gz-1"}},
+		{"none", []FleetSelector{FleetNamed("x")}, nil},
+	}
+	for _, c := range cases {
+		if got := acFleetNames(f.Select(c.selectors...)); !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("%s: %v, want %v", c.name, got, c.want)
+		}
+	}
+	if err := f.Add("sh-1", NewAC("127.0.0.1:1", "secret"), nil); err == nil {
+		t.Fatal("want error for duplicate name")
+	}
+	f.Remove("sh-2")
+	if got := acFleetNames(f); !reflect.DeepEqual(got, []string{"sh-1", "bj-1", "gz-1"}) || f.Get("sh-2") != nil {
+		t.Fatalf("after remove: %v", got)
+	}
+}
+
+// TestFleetDo 结果按设备添加顺序排列,panic记录为该设备的错误
+func TestFleetDo(t *testing.T) {
+	f := testFleet(t)
+	rs := f.Do(context.Background(), func(name string, ac *AC) (interface{}, error) {
+		switch name {
+		case "sh-1":
+			time.Sleep(20 * time.Millisecond) // 最后完成,仍排在第一位
+			return "v1", nil
+		case "bj-1":
+			return nil, errors.New("timeout")
+		case "gz-1":
+			panic("boom")
+		}
+		return "v2", nil
+	})
+	var names []string
+	for _, r := range rs {
+		names = append(names, r.Device)
+	}
+	if want := []string{"sh-1", "sh-2", "bj-1", "gz-1"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("order = %v, want %v", names, want)
+	}
+	if rs[0].Value != "v1" || rs[0].Duration < 20*time.Millisecond || rs[3].Err == nil || rs[3].Error != "panic: boom" {
+		t.Fatalf("results = %+v", rs)
+	}
+	if v := rs.Values(); !reflect.DeepEqual(v, map[string]interface{}{"sh-1": "v1", "sh-2": "v2"}) {
+		t.Fatalf("values = %v", v)
+	}
+	if err := rs.Err(); err == nil || err.Error() != "2 of 4 devices failed: bj-1: timeout; gz-1: panic: boom" {
+		t.Fatalf("err = %v", err)
+	}
+	if err := rs[:2].Err(); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+}
+
+// TestFleetConcurrency 同时执行的设备数不超过Concurrency
+func TestFleetConcurrency(t *testing.T) {
+	f := testFleet(t)
+	f.Concurrency = 2
+	var (
+		mu        sync.Mutex
+		cur, peak int
+	)
+	rs := f.Do(context.Background(), func(name string, ac *AC) (interface{}, error) {
+		mu.Lock()
+		if cur++; cur > peak {
+			peak = cur
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		cur--
+		mu.Unlock()
+		return nil, nil
+	})
+	if err := rs.Err(); err != nil || peak != 2 {
+		t.Fatalf("err = %v, peak = %d, want 2", err, peak)
+	}
+}
+
+// TestFleetCancelWaiting 等待并发槽位时ctx结束,未执行的设备返回ctx错误
+func TestFleetCancelWaiting(t *testing.T) {
+	f := testFleet(t)
+	f.Concurrency = 1
+	ctx, cancel := context.WithCancel(context.Background())
+	var (
+		mu  sync.Mutex
+		ran []string
+	)
+	rs := f.Do(ctx, func(name string, ac *AC) (interface{}, error) {
+		mu.Lock()
+		ran = append(ran, name)
+		mu.Unlock()
+		cancel()
+		<-ac.ctx.Done()
+		return nil, ac.ctx.Err()
+	})
+	if len(ran) != 1 {
+		t.Fatalf("ran = %v, want only the first device to start", ran)
+	}
+	for _, r := range rs {
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Fatalf("%s: err = %v, want canceled", r.Device, r.Err)
+		}
+	}
+	if err := rs.Err(); !strings.HasPrefix(fmt.Sprint(err), "4 of 4 devices failed") {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+/**
+ * @Description: flux policy channel tree with parsed bandwidth values
+ * @File:  fluxtree
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Bandwidth 带宽值,单位为bps
+type Bandwidth struct {
+	Bps       int64 `json:"bps"`
+	Unlimited bool  `json:"unlimited"` // 无限制(-1)
+}
+
+// ParseBandwidth 解析带宽值,-1表示无限制
+// 支持单位bps,kbps,mbps,gbps(或kb/s,k,m,g等,不区分大小写,b均按bit计算)
+// 无单位时按kbps计算,假定与AC流控通道配置页面的单位一致(接口文档未说明)
+// e.g: -1, 2048, 100Mbps, 1.5G, 512kb/s
+func ParseBandwidth(s string) (Bandwidth, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	if v == "-1" {
+		return Bandwidth{Unlimited: true}, nil
+	}
+	if v == "" {
+		return Bandwidth{}, acArgErrorf("invalid bandwidth %q", s)
+	}
+	v = strings.TrimSuffix(v, "/s")
+	mul := float64(1000) // 默认kbps
+	for _, u := range []struct {
+		suffix string
+		mul    float64
+	}{
+		{"gbps", 1e9}, {"mbps", 1e6}, {"kbps", 1e3}, {"bps", 1},
+		{"gb", 1e9}, {"mb", 1e6}, {"kb", 1e3}, {"b", 1},
+		{"g", 1e9}, {"m", 1e6}, {"k", 1e3},
+	} {
+		if strings.HasSuffix(v, u.suffix) {
+			v, mul = strings.TrimSpace(strings.TrimSuffix(v, u.suffix)), u.mul
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		return Bandwidth{}, acArgErrorf("invalid bandwidth %q", s)
+	}
+	return Bandwidth{Bps: int64(f * mul)}, nil
+}
+
+func (b Bandwidth) String() string {
+	if b.Unlimited {
+		return "unlimited"
+	}
+	for _, u := range []struct {
+		name string
+		div  int64
+	}{{"Gbps", 1e9}, {"Mbps", 1e6}, {"Kbps", 1e3}} {
+		if b.Bps >= u.div {
+			return strconv.FormatFloat(float64(b.Bps)/float64(u.div), 'f', -1, 64) + u.name
+		}
+	}
+	return strconv.FormatInt(b.Bps, 10) + "bps"
+}
+
+// Less 带宽比较,无限制大于任何有限值
+func (b Bandwidth) Less(o Bandwidth) bool {
+	switch {
+	case b.Unlimited:
+		return false
+	case o.Unlimited:
+		return true
+	}
+	return b.Bps < o.Bps
+}
+
+// BandwidthPair 上行和下行带宽
+type BandwidthPair struct {
+	Up   Bandwidth `json:"up"`
+	Down Bandwidth `json:"down"`
+}
+
+// acParsePair 解析AC返回的[上行,下行]数组,缺失或无法解析的值视为无限制
+func acParsePair(v []string) (BandwidthPair, error) {
+	p := BandwidthPair{Up: Bandwidth{Unlimited: true}, Down: Bandwidth{Unlimited: true}}
+	for i, dst := range []*Bandwidth{&p.Up, &p.Down} {
+		if i >= len(v) {
+			break
+		}
+		b, err := ParseBandwidth(v[i])
+		if err != nil {
+			return p, err
+		}
+		*dst = b
+	}
+	return p, nil
+}
+
+// FluxChannel 流控通道
+type FluxChannel struct {
+	Policy   FluxPolicy     `json:"policy"`
+	Assured  BandwidthPair  `json:"assured"` // 保证带宽
+	Max      BandwidthPair  `json:"max"`     // 最大带宽
+	Single   BandwidthPair  `json:"single"`  // 单用户限制带宽
+	Objects  []string       `json:"objects"`
+	Services []string       `json:"services"`
+	Parent   *FluxChannel   `json:"-"`
+	Children []*FluxChannel `json:"children,omitempty"`
+}
+
+// Path 从根通道到当前通道的名称路径,e.g:线路1/办公/视频
+func (c *FluxChannel) Path() string {
+	var names []string
+	for n := c; n != nil; n = n.Parent {
+		names = append([]string{n.Policy.Name}, names...)
+	}
+	return strings.Join(names, "/")
+}
+
+// FluxTree 流控通道树
+type FluxTree struct {
+	Roots  []*FluxChannel `json:"roots"`
+	Errors []string       `json:"errors,omitempty"` // 无法解析的带宽值及无效的父通道
+
+	byID map[string]*FluxChannel
+}
+
+// Oversubscription 子通道保证带宽之和超过父通道最大带宽
+type Oversubscription struct {
+	Parent    *FluxChannel `json:"-"`
+	Channel   string       `json:"channel"`   // 父通道路径
+	Direction string       `json:"direction"` // up/down
+	Assured   Bandwidth    `json:"assured"`   // 子通道保证带宽之和
+	Max       Bandwidth    `json:"max"`       // 父通道最大带宽
+}
+
+// FluxPolicyTree 获取流控策略并建立通道树
+func (ac *AC) FluxPolicyTree() (*FluxTree, error) {
+	policies, err := ac.PolicyFluxGet()
+	if err != nil {
+		return nil, err
+	}
+	return NewFluxTree(policies), nil
+}
+
+// NewFluxTree 按FatherId(通道ID或通道名)建立通道树,父通道不存在的通道作为根通道
+func NewFluxTree(policies []FluxPolicy) *FluxTree {
+	var (
+		t      = &FluxTree{byID: make(map[string]*FluxChannel)}
+		byName = make(map[string]*FluxChannel)
+		list   = make([]*FluxChannel, 0, len(policies))
+	)
+	for _, p := range policies {
+		c := &FluxChannel{Policy: p, Objects: acSplitList(p.Object), Services: acSplitList(p.Service)}
+		var err error
+		if c.Assured, err = acParsePair(p.Assured); err != nil {
+			t.Errors = append(t.Errors, fmt.Sprintf("channel %s assured: %v", p.Name, err))
+		}
+		if c.Max, err = acParsePair(p.Max); err != nil {
+			t.Errors = append(t.Errors, fmt.Sprintf("channel %s max: %v", p.Name, err))
+		}
+		if c.Single, err = acParsePair(p.Single); err != nil {
+			t.Errors = append(t.Errors, fmt.Sprintf("channel %s single: %v", p.Name, err))
+		}
+		if p.Id != "" {
+			t.byID[p.Id] = c
+		}
+		if _, ok := byName[p.Name]; !ok {
+			byName[p.Name] = c
+		}
+		list = append(list, c)
+	}
+	for _, c := range list {
+		fid := c.Policy.FatherId
+		parent := t.byID[fid]
+		if parent == nil {
+			parent = byName[fid]
+		}
+		if parent == nil || parent == c || acIsDescendant(parent, c) {
+			if parent != nil {
+				t.Errors = append(t.Errors, fmt.Sprintf("channel %s: cyclic father %s", c.Policy.Name, fid))
+			}
+			t.Roots = append(t.Roots, c)
+			continue
+		}
+		c.Parent = parent
+		parent.Children = append(parent.Children, c)
+	}
+	return t
+}
+
+// acIsDescendant n是否为c的后代(用于检测循环引用)
+func acIsDescendant(n, c *FluxChannel) bool {
+	for p := n; p != nil; p = p.Parent {
+		if p == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Channel 按通道ID查找
+func (t *FluxTree) Channel(id string) *FluxChannel {
+	return t.byID[id]
+}
+
+// Walk 深度优先遍历通道,fn返回false时不再遍历其子通道
+func (t *FluxTree) Walk(fn func(c *FluxChannel, depth int) bool) {
+	var walk func(list []*FluxChannel, depth int)
+	walk = func(list []*FluxChannel, depth int) {
+		for _, c := range list {
+			if fn(c, depth) {
+				walk(c.Children, depth+1)
+			}
+		}
+	}
+	walk(t.Roots, 0)
+}
+
+// FindByObject 查找适用对象(用户,组,位置,终端等)包含obj的通道
+func (t *FluxTree) FindByObject(obj string) []*FluxChannel {
+	return t.find(func(c *FluxChannel) bool { return acContains(c.Objects, obj) })
+}
+
+// FindByService 查找适用应用包含service的通道
+func (t *FluxTree) FindByService(service string) []*FluxChannel {
+	return t.find(func(c *FluxChannel) bool { return acContains(c.Services, service) })
+}
+
+func (t *FluxTree) find(match func(c *FluxChannel) bool) []*FluxChannel {
+	var r []*FluxChannel
+	t.Walk(func(c *FluxChannel, depth int) bool {
+		if match(c) {
+			r = append(r, c)
+		}
+		return true
+	})
+	return r
+}
+
+// Oversubscribed 检测子通道保证带宽之和超过父通道最大带宽的情况(无限制的保证带宽不计入)
+func (t *FluxTree) Oversubscribed() []Oversubscription {
+	var r []Oversubscription
+	t.Walk(func(c *FluxChannel, depth int) bool {
+		if len(c.Children) == 0 {
+			return true
+		}
+		var up, down int64
+		for _, child := range c.Children {
+			if !child.Assured.Up.Unlimited {
+				up += child.Assured.Up.Bps
+			}
+			if !child.Assured.Down.Unlimited {
+				down += child.Assured.Down.Bps
+			}
+		}
+		if sum := (Bandwidth{Bps: up}); c.Max.Up.Less(sum) {
+			r = append(r, Oversubscription{Parent: c, Channel: c.Path(), Direction: "up", Assured: sum, Max: c.Max.Up})
+		}
+		if sum := (Bandwidth{Bps: down}); c.Max.Down.Less(sum) {
+			r = append(r, Oversubscription{Parent: c, Channel: c.Path(), Direction: "down", Assured: sum, Max: c.Max.Down})
+		}
+		return true
+	})
+	sort.SliceStable(r, func(i, j int) bool { return r[i].Channel < r[j].Channel })
+	return r
+}
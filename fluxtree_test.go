@@ -0,0 +1,119 @@
+package sangfor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Bandwidth
+	}{
+		{"-1", Bandwidth{Unlimited: true}},
+		{" -1 ", Bandwidth{Unlimited: true}},
+		{"2048", Bandwidth{Bps: 2048000}}, // 无单位按kbps
+		{"0", Bandwidth{}},
+		{"500bps", Bandwidth{Bps: 500}},
+		{"64Kbps", Bandwidth{Bps: 64000}},
+		{"100Mbps", Bandwidth{Bps: 100000000}},
+		{"1.5G", Bandwidth{Bps: 1500000000}},
+		{"2 gbps", Bandwidth{Bps: 2000000000}},
+		{"512kb/s", Bandwidth{Bps: 512000}},
+		{"10MB/s", Bandwidth{Bps: 10000000}},
+		{"20m", Bandwidth{Bps: 20000000}},
+		{"8b", Bandwidth{Bps: 8}},
+	}
+	for _, c := range cases {
+		got, err := ParseBandwidth(c.in)
+		if err != nil || got != c.want {
+			t.Fatalf("ParseBandwidth(%q) = %+v, %v, want %+v", c.in, got, err, c.want)
+		}
+	}
+	for _, in := range []string{"", "fast", "-2", "-5mbps", "10tbps", "mbps"} {
+		if _, err := ParseBandwidth(in); !IsArgError(err) {
+			t.Fatalf("ParseBandwidth(%q) err = %v, want ArgError", in, err)
+		}
+	}
+	if s := (Bandwidth{Bps: 1500000}).String(); s != "1.5Mbps" {
+		t.Fatalf("String() = %s", s)
+	}
+}
+
+func testFluxTree() *FluxTree {
+	return NewFluxTree([]FluxPolicy{
+		{Id: "1", Name: "线路1", Max: []string{"100mbps", "100mbps"}},
+		{Id: "2", Name: "办公", FatherId: "1", Object: "/office, /dev", Service: "http", Assured: []string{"60mbps", "20mbps"}},
+		{Id: "3", Name: "视频", FatherId: "线路1", Object: "/guest", Service: "video,http", Assured: []string{"50mbps", "-1"}},
+		{Id: "4", Name: "会议", FatherId: "2", Object: "/dev", Max: []string{"10mbps"}},
+		{Id: "5", Name: "孤立", FatherId: "missing"},
+		{Id: "6", Name: "A", FatherId: "7"},
+		{Id: "7", Name: "B", FatherId: "6"},
+		{Id: "8", Name: "坏值", Max: []string{"fast"}},
+	})
+}
+
+func acChannelPaths(list []*FluxChannel) []string {
+	var r []string
+	for _, c := range list {
+		r = append(r, c.Path())
+	}
+	return r
+}
+
+// TestFluxTreeParents 按ID或名称解析父通道,父通道不存在或循环引用时作为根通道
+func TestFluxTreeParents(t *testing.T) {
+	tree := testFluxTree()
+	if got, want := acChannelPaths(tree.Roots), []string{"线路1", "孤立", "B", "坏值"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("roots = %v, want %v", got, want)
+	}
+	if c := tree.Channel("4"); c == nil || c.Path() != "线路1/办公/会议" {
+		t.Fatalf("channel 4 = %+v", c)
+	}
+	if c := tree.Channel("3"); c.Parent != tree.Channel("1") {
+		t.Fatalf("father by name: parent = %+v", c.Parent)
+	}
+	if c := tree.Channel("6"); c.Path() != "B/A" {
+		t.Fatalf("cycle: path = %s", c.Path())
+	}
+	if c := tree.Channel("4"); c.Max.Down != (Bandwidth{Unlimited: true}) || c.Max.Up.Bps != 10000000 {
+		t.Fatalf("missing down max = %+v, want unlimited", c.Max)
+	}
+	if len(tree.Errors) != 2 || !strings.Contains(tree.Errors[0], "坏值 max") || !strings.Contains(tree.Errors[1], "cyclic father 6") {
+		t.Fatalf("errors = %v", tree.Errors)
+	}
+
+	var walked []string
+	tree.Walk(func(c *FluxChannel, depth int) bool {
+		walked = append(walked, strings.Repeat("-", depth)+c.Policy.Name)
+		return c.Policy.Name != "办公"
+	})
+	if want := []string{"线路1", "-办公", "-视频", "孤立", "B", "-A", "坏值"}; !reflect.DeepEqual(walked, want) {
+		t.Fatalf("walk = %v, want %v", walked, want)
+	}
+}
+
+func TestFluxTreeFind(t *testing.T) {
+	tree := testFluxTree()
+	if got, want := acChannelPaths(tree.FindByObject("/dev")), []string{"线路1/办公", "线路1/办公/会议"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("FindByObject = %v, want %v", got, want)
+	}
+	if got, want := acChannelPaths(tree.FindByService("http")), []string{"线路1/办公", "线路1/视频"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("FindByService = %v, want %v", got, want)
+	}
+	if got := tree.FindByObject("/none"); got != nil {
+		t.Fatalf("FindByObject = %v, want none", acChannelPaths(got))
+	}
+}
+
+// TestFluxTreeOversubscribed 上行保证带宽60+50超过100,下行20(无限制不计入)未超过
+func TestFluxTreeOversubscribed(t *testing.T) {
+	r := testFluxTree().Oversubscribed()
+	if len(r) != 1 {
+		t.Fatalf("oversubscribed = %+v, want 1", r)
+	}
+	if o := r[0]; o.Channel != "线路1" || o.Direction != "up" || o.Assured.Bps != 110000000 || o.Max.Bps != 100000000 {
+		t.Fatalf("oversubscription = %+v", o)
+	}
+}
@@ -0,0 +1,270 @@
+/**
+ * @Description: time-boxed net policy grants
+ * @File:  grant
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PolicyGrant 临时授予用户的上网策略
+type PolicyGrant struct {
+	User      string    `json:"user"`                 // 用户名
+	Policy    string    `json:"policy"`               // 上网策略名
+	Reason    string    `json:"reason,omitempty"`     // 授权原因
+	GrantedAt time.Time `json:"granted_at"`           // 授权时间
+	ExpireAt  time.Time `json:"expire_at"`            // 到期时间
+	Existing  bool      `json:"existing,omitempty"`   // 授权前用户已关联该策略,到期时不删除
+	RevokedAt time.Time `json:"revoked_at,omitempty"` // 撤销时间(仅出现在撤销结果中)
+	Dropped   bool      `json:"dropped,omitempty"`    // 策略已在设备上被手工移除(仅出现在对账结果中)
+}
+
+// GrantStore 授权记录持久化
+type GrantStore interface {
+	LoadGrants() ([]PolicyGrant, error)
+	SaveGrants(grants []PolicyGrant) error
+}
+
+// FileGrantStore 使用本地JSON文件持久化授权记录
+type FileGrantStore struct {
+	Path string
+}
+
+func (s *FileGrantStore) LoadGrants() ([]PolicyGrant, error) {
+	var grants []PolicyGrant
+	_, err := acLoadJSON(s.Path, &grants)
+	return grants, err
+}
+
+func (s *FileGrantStore) SaveGrants(grants []PolicyGrant) error {
+	if grants == nil {
+		grants = []PolicyGrant{}
+	}
+	return acSaveJSON(s.Path, grants)
+}
+
+// GrantManager 临时策略授权管理
+// 授权时通过 UserNetPolicySet(opr=add) 关联策略并持久化到期时间,到期后通过 opr=del 撤销,
+// 所有状态均保存在 Store 中,进程重启后可继续撤销到期的授权
+type GrantManager struct {
+	AC    *AC
+	Store GrantStore
+	mu    sync.Mutex
+}
+
+// NewGrantManager 创建授权管理,store可使用 FileGrantStore
+func NewGrantManager(ac *AC, store GrantStore) *GrantManager {
+	return &GrantManager{AC: ac, Store: store}
+}
+
+// Grant 为用户临时授予上网策略,有效期为d
+// 同一用户同一策略已存在授权时延长到期时间
+func (m *GrantManager) Grant(user, policy string, d time.Duration, reason string) (*PolicyGrant, error) {
+	if user == "" || policy == "" || d <= 0 {
+		return nil, acErrArg()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	grants, err := m.Store.LoadGrants()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range grants {
+		g := &grants[i]
+		if g.User == user && g.Policy == policy {
+			if expire := now.Add(d); expire.After(g.ExpireAt) {
+				g.ExpireAt = expire
+			}
+			if reason != "" {
+				g.Reason = reason
+			}
+			r := *g
+			return &r, m.Store.SaveGrants(grants)
+		}
+	}
+	existing, err := m.hasPolicy(user, policy)
+	if err != nil {
+		return nil, err
+	}
+	g := PolicyGrant{User: user, Policy: policy, Reason: reason, GrantedAt: now, ExpireAt: now.Add(d), Existing: existing}
+	if !existing {
+		if _, err = m.AC.UserNetPolicySet(UserPolicySet{Opr: "add", User: user, Policy: []string{policy}}); err != nil {
+			return nil, err
+		}
+	}
+	// 先在设备上生效再落盘,落盘失败时回滚,避免出现无记录的授权
+	if err = m.Store.SaveGrants(append(grants, g)); err != nil {
+		if !existing {
+			if _, rbErr := m.AC.UserNetPolicySet(UserPolicySet{Opr: "del", User: user, Policy: []string{policy}}); rbErr != nil {
+				return nil, fmt.Errorf("%w (rollback policy %s for user %s: %v)", err, policy, user, rbErr)
+			}
+		}
+		return nil, err
+	}
+	return &g, nil
+}
+
+// Revoke 立即撤销用户的临时授权
+func (m *GrantManager) Revoke(user, policy string) (*PolicyGrant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	revoked, err := m.revoke(func(g PolicyGrant) bool { return g.User == user && g.Policy == policy })
+	if err != nil {
+		return nil, err
+	}
+	if len(revoked) == 0 {
+		return nil, fmt.Errorf("grant %s for user %s not found", policy, user)
+	}
+	return &revoked[0], nil
+}
+
+// RevokeDue 撤销所有在now之前到期的授权,返回已撤销的授权
+func (m *GrantManager) RevokeDue(now time.Time) ([]PolicyGrant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.revoke(func(g PolicyGrant) bool { return !g.ExpireAt.After(now) })
+}
+
+// revoke 撤销匹配的授权,撤销失败的授权保留在存储中等待下次重试
+func (m *GrantManager) revoke(match func(g PolicyGrant) bool) ([]PolicyGrant, error) {
+	grants, err := m.Store.LoadGrants()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		kept    = grants[:0:0]
+		revoked []PolicyGrant
+		errs    []string
+		now     = time.Now()
+	)
+	for _, g := range grants {
+		if !match(g) {
+			kept = append(kept, g)
+			continue
+		}
+		if !g.Existing {
+			_, err = m.AC.UserNetPolicySet(UserPolicySet{Opr: "del", User: g.User, Policy: []string{g.Policy}})
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s/%s: %v", g.User, g.Policy, err))
+				kept = append(kept, g)
+				continue
+			}
+		}
+		g.RevokedAt = now
+		revoked = append(revoked, g)
+	}
+	if len(revoked) > 0 {
+		if err = m.Store.SaveGrants(kept); err != nil {
+			return revoked, err
+		}
+	}
+	if len(errs) > 0 {
+		return revoked, fmt.Errorf("revoke grants: %s", strings.Join(errs, "; "))
+	}
+	return revoked, nil
+}
+
+// Reconcile 与设备对账,移除策略已在设备上被手工删除的授权记录,返回被移除的授权
+func (m *GrantManager) Reconcile() ([]PolicyGrant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	grants, err := m.Store.LoadGrants()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		kept    = grants[:0:0]
+		dropped []PolicyGrant
+		errs    []string
+		cache   = make(map[string]map[string]bool)
+		failed  = make(map[string]bool)
+	)
+	for _, g := range grants {
+		policies, ok := cache[g.User]
+		if !ok && !failed[g.User] {
+			policies, err = m.userPolicies(g.User)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", g.User, err))
+				failed[g.User] = true
+			} else {
+				cache[g.User] = policies
+			}
+		}
+		if failed[g.User] || policies[g.Policy] { // 查询失败的用户保留授权,下次对账时重试
+			kept = append(kept, g)
+			continue
+		}
+		g.Dropped = true
+		dropped = append(dropped, g)
+	}
+	if len(dropped) > 0 {
+		if err = m.Store.SaveGrants(kept); err != nil {
+			return dropped, err
+		}
+	}
+	if len(errs) > 0 {
+		return dropped, fmt.Errorf("reconcile grants: %s", strings.Join(errs, "; "))
+	}
+	return dropped, nil
+}
+
+// Grants 返回当前所有有效的授权记录
+func (m *GrantManager) Grants() ([]PolicyGrant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Store.LoadGrants()
+}
+
+// Run 按间隔对账并撤销到期的授权,直到ctx结束,错误交由onErr处理(可为nil)
+func (m *GrantManager) Run(ctx context.Context, interval time.Duration, onErr func(error)) error {
+	if interval <= 0 {
+		return acErrArg()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := m.Reconcile(); err != nil && onErr != nil {
+			onErr(err)
+		}
+		if _, err := m.RevokeDue(time.Now()); err != nil && onErr != nil {
+			onErr(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *GrantManager) hasPolicy(user, policy string) (bool, error) {
+	policies, err := m.userPolicies(user)
+	if err != nil {
+		return false, err
+	}
+	return policies[policy], nil
+}
+
+// userPolicies 通过 UserGet 获取用户直接关联的上网策略
+func (m *GrantManager) userPolicies(user string) (map[string]bool, error) {
+	detail, err := m.AC.UserGet(user)
+	if err != nil {
+		return nil, err
+	}
+	policies := make(map[string]bool)
+	if detail == nil {
+		return policies, nil
+	}
+	for _, p := range detail.Policy {
+		policies[p.Name] = true
+	}
+	return policies, nil
+}
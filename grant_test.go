@@ -0,0 +1,162 @@
+package sangfor
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGrantReconcile(t *testing.T) {
+	ac, _ := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		if r.URL.Query().Get("name") == "gone" {
+			return nil, errors.New("用户不存在")
+		}
+		return map[string]interface{}{"name": "a", "policy": []map[string]interface{}{{"name": "p1"}}}, nil
+	})
+	store := &FileGrantStore{Path: filepath.Join(t.TempDir(), "grants.json")}
+	if err := store.SaveGrants([]PolicyGrant{{User: "gone", Policy: "p"}, {User: "a", Policy: "p1"}, {User: "a", Policy: "p2"}}); err != nil {
+		t.Fatal(err)
+	}
+	m := NewGrantManager(ac, store)
+	dropped, err := m.Reconcile()
+	if err == nil {
+		t.Fatal("want error for user gone")
+	}
+	if len(dropped) != 1 || dropped[0].User != "a" || dropped[0].Policy != "p2" {
+		t.Fatalf("dropped = %+v, want a/p2", dropped)
+	}
+	kept, err := m.Grants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 2 || kept[0].User != "gone" || kept[1].Policy != "p1" {
+		t.Fatalf("kept = %+v, want gone/p and a/p1", kept)
+	}
+}
+
+// grantDevice 模拟用户直接关联的上网策略,delErr不为空时撤销策略失败
+func grantDevice(t *testing.T, policies map[string]bool, delErr error) *AC {
+	ac, _ := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		switch endpoint {
+		case acNetPolicy:
+			return []interface{}{
+				map[string]interface{}{"policy_info": map[string]interface{}{"name": "p1", "status": true}},
+				map[string]interface{}{"policy_info": map[string]interface{}{"name": "p2", "status": true}},
+			}, nil
+		case acUser:
+			var list []interface{}
+			for name := range policies {
+				list = append(list, map[string]interface{}{"name": name})
+			}
+			return map[string]interface{}{"name": r.URL.Query().Get("name"), "policy": list}, nil
+		case acUserNetPolicy:
+			var set UserPolicySet
+			_ = json.NewDecoder(r.Body).Decode(&set)
+			if set.Opr == "del" && delErr != nil {
+				return nil, delErr
+			}
+			for _, p := range set.Policy {
+				policies[p] = set.Opr == "add"
+				if set.Opr == "del" {
+					delete(policies, p)
+				}
+			}
+			return "ok", nil
+		}
+		return nil, errors.New("unexpected request")
+	})
+	return ac
+}
+
+func TestGrantRevokeDue(t *testing.T) {
+	var (
+		policies = map[string]bool{"p1": true}
+		ac       = grantDevice(t, policies, nil)
+		store    = &FileGrantStore{Path: filepath.Join(t.TempDir(), "grants.json")}
+		m        = NewGrantManager(ac, store)
+	)
+	g2, err := m.Grant("a", "p2", time.Hour, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g2.Existing || !policies["p2"] {
+		t.Fatalf("grant p2 = %+v, policies = %v, want p2 added", g2, policies)
+	}
+	g1, err := m.Grant("a", "p1", time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !g1.Existing {
+		t.Fatal("p1 was already assigned and should be marked existing")
+	}
+	again, err := m.Grant("a", "p2", 2*time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.ExpireAt.After(g2.ExpireAt) || again.Reason != "test" {
+		t.Fatalf("regrant = %+v, want extended expiry and kept reason", again)
+	}
+
+	// 重新加载存储,模拟进程重启
+	m = NewGrantManager(ac, &FileGrantStore{Path: store.Path})
+	grants, err := m.Grants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grants) != 2 || !grants[0].ExpireAt.Equal(again.ExpireAt) {
+		t.Fatalf("persisted grants = %+v", grants)
+	}
+	revoked, err := m.RevokeDue(time.Now().Add(90 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 1 || revoked[0].Policy != "p1" || !policies["p1"] {
+		t.Fatalf("revoked = %+v, policies = %v, want p1 record revoked and p1 kept", revoked, policies)
+	}
+	if revoked, err = m.RevokeDue(time.Now().Add(3 * time.Hour)); err != nil || len(revoked) != 1 || policies["p2"] {
+		t.Fatalf("revoked = %+v, err = %v, policies = %v, want p2 removed", revoked, err, policies)
+	}
+	if grants, _ = m.Grants(); len(grants) != 0 {
+		t.Fatalf("grants = %+v, want none", grants)
+	}
+}
+
+// TestGrantRevokeFailed 撤销失败的授权保留在存储中等待重试
+func TestGrantRevokeFailed(t *testing.T) {
+	var (
+		policies = map[string]bool{}
+		store    = &FileGrantStore{Path: filepath.Join(t.TempDir(), "grants.json")}
+		m        = NewGrantManager(grantDevice(t, policies, errors.New("device busy")), store)
+	)
+	if _, err := m.Grant("a", "p2", time.Minute, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.RevokeDue(time.Now().Add(time.Hour)); err == nil {
+		t.Fatal("want revoke error")
+	}
+	if grants, _ := m.Grants(); len(grants) != 1 || !policies["p2"] {
+		t.Fatalf("grants = %+v, want p2 kept for retry", grants)
+	}
+}
+
+// failingGrantStore 加载为空,保存总是失败
+type failingGrantStore struct{}
+
+func (failingGrantStore) LoadGrants() ([]PolicyGrant, error) { return nil, nil }
+func (failingGrantStore) SaveGrants([]PolicyGrant) error     { return errors.New("disk full") }
+
+// TestGrantRollback 授权落盘失败时回滚设备上的策略,回滚失败时一并返回
+func TestGrantRollback(t *testing.T) {
+	policies := map[string]bool{}
+	if _, err := NewGrantManager(grantDevice(t, policies, nil), failingGrantStore{}).Grant("a", "p2", time.Hour, ""); err == nil || policies["p2"] {
+		t.Fatalf("err = %v, policies = %v, want error and p2 rolled back", err, policies)
+	}
+	_, err := NewGrantManager(grantDevice(t, policies, errors.New("device busy")), failingGrantStore{}).Grant("a", "p2", time.Hour, "")
+	if err == nil || !strings.Contains(err.Error(), "rollback") || !policies["p2"] {
+		t.Fatalf("err = %v, want rollback error", err)
+	}
+}
@@ -0,0 +1,33 @@
+package sangfor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// acTestDevice 模拟AC,fn按接口路径(如status/version)返回data或错误信息
+type acTestDevice struct {
+	*httptest.Server
+	calls []string
+}
+
+// newTestAC 创建连接到模拟设备的AC,fn返回code为0时的data,返回error时以code=1及其信息响应
+func newTestAC(t *testing.T, fn func(endpoint string, r *http.Request) (interface{}, error)) (*AC, *acTestDevice) {
+	t.Helper()
+	d := &acTestDevice{}
+	d.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint := strings.TrimPrefix(r.URL.Path, "/v1/")
+		d.calls = append(d.calls, endpoint)
+		data, err := fn(endpoint, r)
+		resp := map[string]interface{}{"code": 0, "data": data}
+		if err != nil {
+			resp = map[string]interface{}{"code": 1, "message": err.Error()}
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(d.Close)
+	return NewAC(strings.TrimPrefix(d.URL, "http://"), "secret"), d
+}
@@ -0,0 +1,532 @@
+/**
+ * @Description: traffic history collector and rollup store
+ * @File:  history
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolution 汇总粒度
+type Resolution string
+
+const (
+	Res5m Resolution = "5m" // 5分钟,按天分文件
+	Res1h Resolution = "1h" // 1小时,按月分文件
+	Res1d Resolution = "1d" // 1天,按年分文件
+
+	acHistoryRaw        = "raw"          // 原始采样目录,按天分文件
+	acHistoryCounterTTL = 24 * time.Hour // 排行中消失的用户或应用保留上一次计数的时长
+)
+
+var acResolutions = []Resolution{Res5m, Res1h, Res1d}
+
+// Duration 粒度时长(1d按24小时计)
+func (r Resolution) Duration() time.Duration {
+	switch r {
+	case Res5m:
+		return 5 * time.Minute
+	case Res1h:
+		return time.Hour
+	case Res1d:
+		return 24 * time.Hour
+	}
+	return 0
+}
+
+// bucket 返回t所在汇总区间的起始时间(按t所在时区对齐)
+func (r Resolution) bucket(t time.Time) time.Time {
+	switch r {
+	case Res5m:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()-t.Minute()%5, 0, 0, t.Location())
+	case Res1h:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// next 返回下一个汇总区间的起始时间
+func (r Resolution) next(start time.Time) time.Time {
+	if r == Res1d {
+		return start.AddDate(0, 0, 1)
+	}
+	return r.bucket(start.Add(r.Duration()))
+}
+
+// fileKey 汇总数据所在文件名(不含扩展名)
+func (r Resolution) fileKey(t time.Time) string {
+	switch r {
+	case Res1h:
+		return t.Format("2006-01")
+	case Res1d:
+		return t.Format("2006")
+	}
+	return t.Format("2006-01-02")
+}
+
+// TrafficSample 一次状态采样
+type TrafficSample struct {
+	Time       time.Time   `json:"time"`
+	Throughput *Throughput `json:"throughput,omitempty"`
+	Users      []UserRank  `json:"users,omitempty"`
+	Apps       []AppRank   `json:"apps,omitempty"`
+}
+
+// TrafficVolume 流量(bytes)
+type TrafficVolume struct {
+	Up    int64 `json:"up"`
+	Down  int64 `json:"down"`
+	Total int64 `json:"total"`
+}
+
+func (v *TrafficVolume) add(o TrafficVolume) {
+	v.Up += o.Up
+	v.Down += o.Down
+	v.Total += o.Total
+}
+
+// UserTraffic 用户流量汇总
+type UserTraffic struct {
+	Name  string `json:"name"`
+	Group string `json:"group,omitempty"`
+	TrafficVolume
+}
+
+// AppTraffic 应用流量汇总(按线路区分)
+type AppTraffic struct {
+	App      string `json:"app"`
+	Line     int    `json:"line"`
+	LineName string `json:"line_name,omitempty"`
+	TrafficVolume
+}
+
+// TrafficRollup 一个汇总区间的数据
+// 用户与应用流量为区间内的增量(设备计数回退时视为重置),吞吐量为区间内的平均值与峰值
+type TrafficRollup struct {
+	Start      time.Time               `json:"start"`
+	Resolution Resolution              `json:"resolution"`
+	Samples    int                     `json:"samples"`
+	Unit       string                  `json:"unit,omitempty"` // 吞吐量单位(bits/bytes)
+	RecvAvg    int64                   `json:"recv_avg"`
+	RecvPeak   int64                   `json:"recv_peak"`
+	SendAvg    int64                   `json:"send_avg"`
+	SendPeak   int64                   `json:"send_peak"`
+	Peak       int64                   `json:"peak"`    // 收发合计峰值
+	PeakAt     time.Time               `json:"peak_at"` // 收发合计峰值出现时间
+	Users      map[string]*UserTraffic `json:"users,omitempty"`
+	Apps       map[string]*AppTraffic  `json:"apps,omitempty"`
+	recvSum    int64
+	sendSum    int64
+	tpSamples  int64
+}
+
+func newTrafficRollup(res Resolution, start time.Time) *TrafficRollup {
+	return &TrafficRollup{
+		Start:      start,
+		Resolution: res,
+		Users:      make(map[string]*UserTraffic),
+		Apps:       make(map[string]*AppTraffic),
+	}
+}
+
+// merge 合并同一粒度或更细粒度的汇总
+func (r *TrafficRollup) merge(o *TrafficRollup) {
+	r.Samples += o.Samples
+	if o.Unit != "" {
+		r.Unit = o.Unit
+	}
+	if o.Peak > r.Peak || r.PeakAt.IsZero() {
+		r.Peak, r.PeakAt = o.Peak, o.PeakAt
+	}
+	if o.RecvPeak > r.RecvPeak {
+		r.RecvPeak = o.RecvPeak
+	}
+	if o.SendPeak > r.SendPeak {
+		r.SendPeak = o.SendPeak
+	}
+	r.recvSum += o.RecvAvg * int64(o.Samples)
+	r.sendSum += o.SendAvg * int64(o.Samples)
+	r.tpSamples += int64(o.Samples)
+	if r.tpSamples > 0 {
+		r.RecvAvg, r.SendAvg = r.recvSum/r.tpSamples, r.sendSum/r.tpSamples
+	}
+	for k, u := range o.Users {
+		if cur, ok := r.Users[k]; ok {
+			cur.add(u.TrafficVolume)
+		} else {
+			c := *u
+			r.Users[k] = &c
+		}
+	}
+	for k, a := range o.Apps {
+		if cur, ok := r.Apps[k]; ok {
+			cur.add(a.TrafficVolume)
+		} else {
+			c := *a
+			r.Apps[k] = &c
+		}
+	}
+}
+
+// acHistoryState 未完成的汇总区间及上一次的设备计数,重启后继续累计
+type acHistoryState struct {
+	Open  map[Resolution]*TrafficRollup `json:"open"`
+	Users map[string]acCounter          `json:"users"`
+	Apps  map[string]acCounter          `json:"apps"`
+}
+
+// acCounter 上一次的设备计数及最后出现的时间
+type acCounter struct {
+	TrafficVolume
+	Seen time.Time `json:"seen"`
+}
+
+// TrafficStore 基于本地文件的流量时序存储
+// 目录结构: raw/日期.jsonl(原始采样), 5m/日期.jsonl, 1h/年月.jsonl, 1d/年.jsonl, state.json
+type TrafficStore struct {
+	Dir        string
+	KeepRaw    bool                         // 是否保存原始采样
+	Retention  map[Resolution]time.Duration // 各粒度数据保留时长,为0时永久保留;原始采样按5m粒度保留
+	Location   *time.Location               // 汇总区间对齐的时区,为空时使用本地时区
+	CounterTTL time.Duration                // 用户或应用从排行中消失多久后丢弃其上一次计数,为0时为24小时
+
+	mu    sync.Mutex
+	state *acHistoryState
+}
+
+// Append 写入一次采样,更新各粒度汇总,区间结束时落盘
+func (s *TrafficStore) Append(sample TrafficSample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	t := sample.Time.In(s.location())
+	if s.KeepRaw {
+		if err := s.appendLine(acHistoryRaw, t.Format("2006-01-02"), sample); err != nil {
+			return err
+		}
+	}
+	delta := newTrafficRollup("", t)
+	delta.Samples = 1
+	if tp := sample.Throughput; tp != nil {
+		delta.Unit = tp.Unit
+		delta.RecvAvg, delta.RecvPeak = int64(tp.Recv), int64(tp.Recv)
+		delta.SendAvg, delta.SendPeak = int64(tp.Send), int64(tp.Send)
+		delta.Peak, delta.PeakAt = int64(tp.Recv+tp.Send), t
+	}
+	for _, u := range sample.Users {
+		cur := TrafficVolume{Up: int64(u.Up), Down: int64(u.Down), Total: int64(u.Total)}
+		if v, ok := acCounterDelta(s.state.Users, u.Name, cur, t); ok {
+			delta.Users[u.Name] = &UserTraffic{Name: u.Name, Group: u.Group, TrafficVolume: v}
+		}
+	}
+	for _, a := range sample.Apps {
+		key := fmt.Sprintf("%d/%s", a.Line, a.App)
+		cur := TrafficVolume{Up: int64(a.Up), Down: int64(a.Down), Total: int64(a.Total)}
+		if v, ok := acCounterDelta(s.state.Apps, key, cur, t); ok {
+			delta.Apps[key] = &AppTraffic{App: a.App, Line: a.Line, LineName: a.LineName, TrafficVolume: v}
+		}
+	}
+	for _, res := range acResolutions {
+		start := res.bucket(t)
+		open := s.state.Open[res]
+		if open != nil && !open.Start.Equal(start) {
+			if err := s.appendLine(string(res), res.fileKey(open.Start), open); err != nil {
+				return err
+			}
+			open = nil
+		}
+		if open == nil {
+			open = newTrafficRollup(res, start)
+			s.state.Open[res] = open
+		}
+		open.merge(delta)
+	}
+	ttl := s.CounterTTL
+	if ttl <= 0 {
+		ttl = acHistoryCounterTTL
+	}
+	acPruneCounters(s.state.Users, t.Add(-ttl))
+	acPruneCounters(s.state.Apps, t.Add(-ttl))
+	if err := acSaveJSON(filepath.Join(s.Dir, "state.json"), s.state); err != nil {
+		return err
+	}
+	return s.prune(t)
+}
+
+// acCounterDelta 计算设备计数的增量,首次出现时只记录不计入,计数回退时视为重置
+func acCounterDelta(prev map[string]acCounter, key string, cur TrafficVolume, seen time.Time) (TrafficVolume, bool) {
+	c, ok := prev[key]
+	last := c.TrafficVolume
+	prev[key] = acCounter{TrafficVolume: cur, Seen: seen}
+	if !ok {
+		return TrafficVolume{}, false
+	}
+	if cur.Total < last.Total || cur.Up < last.Up || cur.Down < last.Down {
+		return cur, true
+	}
+	return TrafficVolume{Up: cur.Up - last.Up, Down: cur.Down - last.Down, Total: cur.Total - last.Total}, true
+}
+
+// acPruneCounters 丢弃before之前最后出现的计数,避免排行中出现过的用户和应用无限累积
+func acPruneCounters(counters map[string]acCounter, before time.Time) {
+	for k, c := range counters {
+		if c.Seen.Before(before) {
+			delete(counters, k)
+		}
+	}
+}
+
+// Query 查询[from,to)内起始的指定粒度汇总(含未完成区间),按时间排序
+func (s *TrafficStore) Query(res Resolution, from, to time.Time) ([]*TrafficRollup, error) {
+	if res.Duration() == 0 {
+		return nil, acArgErrorf("invalid resolution %q", res)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	from, to = from.In(s.location()), to.In(s.location())
+	var (
+		r    []*TrafficRollup
+		seen = make(map[string]bool)
+	)
+	for t := res.bucket(from); t.Before(to); t = res.next(t) {
+		key := res.fileKey(t)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		err := acReadLines(filepath.Join(s.Dir, string(res), key+".jsonl"), func(line []byte) error {
+			var rollup TrafficRollup
+			if err := json.Unmarshal(line, &rollup); err != nil {
+				return err
+			}
+			if !rollup.Start.Before(from) && rollup.Start.Before(to) {
+				r = append(r, &rollup)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if open := s.state.Open[res]; open != nil && !open.Start.Before(from) && open.Start.Before(to) {
+		c := newTrafficRollup(res, open.Start)
+		c.merge(open)
+		r = append(r, c)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Start.Before(r[j].Start) })
+	return r, nil
+}
+
+// Samples 查询[from,to)内的原始采样(需开启 KeepRaw)
+func (s *TrafficStore) Samples(from, to time.Time) ([]TrafficSample, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var r []TrafficSample
+	for t := Res1d.bucket(from.In(s.location())); t.Before(to); t = t.AddDate(0, 0, 1) {
+		err := acReadLines(filepath.Join(s.Dir, acHistoryRaw, t.Format("2006-01-02")+".jsonl"), func(line []byte) error {
+			var sample TrafficSample
+			if err := json.Unmarshal(line, &sample); err != nil {
+				return err
+			}
+			if !sample.Time.Before(from) && sample.Time.Before(to) {
+				r = append(r, sample)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (s *TrafficStore) load() error {
+	if s.state != nil {
+		return nil
+	}
+	if s.Dir == "" {
+		return errors.New("traffic store dir is required")
+	}
+	state := &acHistoryState{}
+	if _, err := acLoadJSON(filepath.Join(s.Dir, "state.json"), state); err != nil {
+		return err
+	}
+	if state.Open == nil {
+		state.Open = make(map[Resolution]*TrafficRollup)
+	}
+	if state.Users == nil {
+		state.Users = make(map[string]acCounter)
+	}
+	if state.Apps == nil {
+		state.Apps = make(map[string]acCounter)
+	}
+	for _, open := range state.Open {
+		// 恢复平均值的累计基数
+		open.recvSum, open.sendSum = open.RecvAvg*int64(open.Samples), open.SendAvg*int64(open.Samples)
+		open.tpSamples = int64(open.Samples)
+		if open.Users == nil {
+			open.Users = make(map[string]*UserTraffic)
+		}
+		if open.Apps == nil {
+			open.Apps = make(map[string]*AppTraffic)
+		}
+	}
+	s.state = state
+	return nil
+}
+
+func (s *TrafficStore) appendLine(dir, key string, v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Join(s.Dir, dir), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(s.Dir, dir, key+".jsonl"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// prune 删除超出保留时长的文件
+func (s *TrafficStore) prune(now time.Time) error {
+	for res, keep := range s.Retention {
+		if keep <= 0 || res.Duration() == 0 {
+			continue
+		}
+		dirs := []string{string(res)}
+		if res == Res5m {
+			dirs = append(dirs, acHistoryRaw)
+		}
+		cutoff := res.fileKey(now.Add(-keep))
+		for _, dir := range dirs {
+			files, err := ioutil.ReadDir(filepath.Join(s.Dir, dir))
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return err
+			}
+			for _, f := range files {
+				// 文件名为日期,字典序即时间序,整个文件早于截止时间才删除
+				if key := strings.TrimSuffix(f.Name(), ".jsonl"); key < cutoff {
+					if err = os.Remove(filepath.Join(s.Dir, dir, f.Name())); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *TrafficStore) location() *time.Location {
+	if s.Location != nil {
+		return s.Location
+	}
+	return time.Local
+}
+
+func acReadLines(path string, fn func(line []byte) error) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err = fn(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// TrafficCollector 周期采样 GetUserRank,GetAppRank,GetThroughput 写入 TrafficStore
+type TrafficCollector struct {
+	AC    *AC
+	Store *TrafficStore
+	Top   int // 用户与应用排行采样数量,为0时为100
+}
+
+// Collect 采样一次并写入存储
+func (c *TrafficCollector) Collect() error {
+	if c.AC == nil || c.Store == nil {
+		return acErrArg()
+	}
+	top := c.Top
+	if top <= 0 {
+		top = 100
+	}
+	var (
+		sample = TrafficSample{Time: time.Now()}
+		err    error
+	)
+	if sample.Throughput, err = c.AC.GetThroughput(); err != nil {
+		return err
+	}
+	if sample.Users, err = c.AC.GetUserRank(UserRankFilter{Top: top}); err != nil {
+		return err
+	}
+	if sample.Apps, err = c.AC.GetAppRank(AppRankFilter{Top: top}); err != nil {
+		return err
+	}
+	for i := range sample.Users {
+		sample.Users[i].Detail = nil
+	}
+	for i := range sample.Apps {
+		sample.Apps[i].UserData = nil
+	}
+	return c.Store.Append(sample)
+}
+
+// Run 按间隔采样,直到ctx结束,单次采样的错误交由onErr处理(可为nil)
+func (c *TrafficCollector) Run(ctx context.Context, interval time.Duration, onErr func(error)) error {
+	if interval <= 0 {
+		return acErrArg()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := c.Collect(); err != nil && onErr != nil {
+			onErr(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
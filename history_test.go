@@ -0,0 +1,111 @@
+package sangfor
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrafficStorePruneCounters(t *testing.T) {
+	s := &TrafficStore{Dir: t.TempDir(), CounterTTL: time.Hour}
+	start := time.Date(2021, 3, 4, 10, 0, 0, 0, time.Local)
+	samples := []TrafficSample{
+		{Time: start, Users: []UserRank{{Name: "a", Total: 10}, {Name: "b", Total: 10}}},
+		{Time: start.Add(30 * time.Minute), Users: []UserRank{{Name: "a", Total: 20}}},
+		{Time: start.Add(90 * time.Minute), Users: []UserRank{{Name: "a", Total: 30}}},
+	}
+	for _, sample := range samples {
+		if err := s.Append(sample); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := s.state.Users["b"]; ok || len(s.state.Users) != 1 {
+		t.Fatalf("counters = %v, want only a", s.state.Users)
+	}
+}
+
+func TestTrafficReportCSV(t *testing.T) {
+	r := &TrafficReport{
+		Peak:     TrafficPeak{At: time.Date(2021, 3, 4, 10, 0, 0, 0, time.Local), Unit: "bps", Peak: 3},
+		TopUsers: []UserTraffic{{Name: "a", Group: "/g"}},
+	}
+	var b strings.Builder
+	if err := r.WriteCSV(&b); err != nil {
+		t.Fatal(err)
+	}
+	sections := strings.Split(b.String(), "\n\n")
+	if len(sections) != 2 || !strings.HasPrefix(sections[0], "peak_at,") || !strings.HasPrefix(sections[1], "section,") {
+		t.Fatalf("csv = %q, want peak and rank tables", b.String())
+	}
+	if strings.Contains(sections[1], "bps") {
+		t.Fatalf("rank table contains peak row: %q", sections[1])
+	}
+}
+
+// TestTrafficRollupQuery 按5m/1h/1d粒度汇总增量与吞吐量,已落盘与未完成区间均可查询,重新加载后结果一致
+func TestTrafficRollupQuery(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		s     = &TrafficStore{Dir: dir, Location: time.UTC}
+		start = time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC)
+	)
+	samples := []struct {
+		at         time.Duration
+		total      int
+		recv, send int
+	}{
+		{0, 100, 10, 1},
+		{2 * time.Minute, 105, 30, 3},
+		{6 * time.Minute, 120, 20, 2},
+		{61 * time.Minute, 10, 40, 4}, // 计数回退视为重置
+	}
+	for _, c := range samples {
+		sample := TrafficSample{Time: start.Add(c.at), Throughput: &Throughput{Recv: c.recv, Send: c.send, Unit: "bits"},
+			Users: []UserRank{{Name: "a", Group: "/g", Total: c.total}}}
+		if err := s.Append(sample); err != nil {
+			t.Fatal(err)
+		}
+	}
+	type want struct {
+		start   time.Duration
+		samples int
+		total   int64
+		recvAvg int64
+		peak    int64
+		peakAt  time.Duration
+	}
+	cases := []struct {
+		res  Resolution
+		want []want
+	}{
+		{Res5m, []want{{0, 2, 5, 20, 33, 2 * time.Minute}, {5 * time.Minute, 1, 15, 20, 22, 6 * time.Minute}, {time.Hour, 1, 10, 40, 44, 61 * time.Minute}}},
+		{Res1h, []want{{0, 3, 20, 20, 33, 2 * time.Minute}, {time.Hour, 1, 10, 40, 44, 61 * time.Minute}}},
+		{Res1d, []want{{-10 * time.Hour, 4, 30, 25, 44, 61 * time.Minute}}},
+	}
+	for _, store := range []*TrafficStore{s, {Dir: dir, Location: time.UTC}} {
+		for _, c := range cases {
+			rollups, err := store.Query(c.res, start.Add(-10*time.Hour), start.Add(2*time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rollups) != len(c.want) {
+				t.Fatalf("%s: %d rollups, want %d", c.res, len(rollups), len(c.want))
+			}
+			for i, w := range c.want {
+				r := rollups[i]
+				var total int64
+				if u := r.Users["a"]; u != nil {
+					total = u.Total
+				}
+				if !r.Start.Equal(start.Add(w.start)) || r.Samples != w.samples || total != w.total ||
+					r.RecvAvg != w.recvAvg || r.Peak != w.peak || !r.PeakAt.Equal(start.Add(w.peakAt)) {
+					t.Fatalf("%s[%d] = start %v samples %d total %d recv_avg %d peak %d at %v, want %+v",
+						c.res, i, r.Start, r.Samples, total, r.RecvAvg, r.Peak, r.PeakAt, w)
+				}
+			}
+		}
+	}
+	if _, err := s.Query("2m", start, start.Add(time.Hour)); !IsArgError(err) {
+		t.Fatalf("err = %v, want invalid resolution", err)
+	}
+}
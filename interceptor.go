@@ -0,0 +1,132 @@
+/**
+ * @Description: interceptor chain around requests sent to the ac
+ * @File:  interceptor
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+// Request 发送到AC的请求描述
+type Request struct {
+	Target   string                 // 设备地址(ip+端口)
+	Endpoint string                 // 接口路径,如 user,status/version
+	Method   string                 // HTTP方法(GET/POST)
+	Query    map[string]string      // 查询参数,不含签名
+	Data     map[string]interface{} // POST请求数据,不含签名
+	Context  context.Context
+	Attempt  int // 重试次数,首次请求为0,见 RetryInterceptor
+}
+
+// Operation 返回请求的实际操作方法(POST请求可通过_method指定为GET/PUT/DELETE等)
+func (r *Request) Operation() string {
+	if m, ok := r.Query["_method"]; ok {
+		return strings.ToUpper(m)
+	}
+	return strings.ToUpper(r.Method)
+}
+
+// Mutating 是否为变更操作
+func (r *Request) Mutating() bool {
+	switch r.Operation() {
+	case "POST", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// Response AC返回的原始响应
+type Response struct {
+	StatusCode int    // HTTP状态码
+	Body       []byte // 响应内容
+}
+
+// Handler 处理请求
+type Handler func(req *Request) (*Response, error)
+
+// Interceptor 请求拦截器,调用next继续处理,也可以直接返回响应
+type Interceptor func(req *Request, next Handler) (*Response, error)
+
+// Use 添加拦截器,先添加的位于外层
+func (ac *AC) Use(interceptors ...Interceptor) {
+	// 复制后追加,避免与 WithContext 的副本共享底层数组
+	ac.interceptors = append(ac.interceptors[:len(ac.interceptors):len(ac.interceptors)], interceptors...)
+}
+
+// handler 构建拦截器链,指标与审计位于最内层以记录实际发出的请求
+func (ac *AC) handler() Handler {
+	h := func(req *Request) (*Response, error) {
+		return ac.observe(req, func(req *Request) (*Response, error) { return ac.audit(req, ac.do) })
+	}
+	for i := len(ac.interceptors) - 1; i >= 0; i-- {
+		h = acChain(ac.interceptors[i], h)
+	}
+	return h
+}
+
+func acChain(i Interceptor, next Handler) Handler {
+	return func(req *Request) (*Response, error) { return i(req, next) }
+}
+
+// TimingInterceptor 请求耗时回调
+func TimingInterceptor(fn func(req *Request, d time.Duration, err error)) Interceptor {
+	return func(req *Request, next Handler) (*Response, error) {
+		start := time.Now()
+		resp, err := next(req)
+		fn(req, time.Since(start), err)
+		return resp, err
+	}
+}
+
+// LoggingInterceptor 记录请求的方法,接口,耗时及错误,logger为空时使用标准log
+func LoggingInterceptor(logger *log.Logger) Interceptor {
+	if logger == nil {
+		logger = log.New(log.Writer(), log.Prefix(), log.Flags())
+	}
+	return func(req *Request, next Handler) (*Response, error) {
+		start := time.Now()
+		resp, err := next(req)
+		if err != nil {
+			logger.Printf("ac %s %s %s error: %v", req.Operation(), req.Endpoint, time.Since(start), err)
+		} else {
+			logger.Printf("ac %s %s %s %d bytes", req.Operation(), req.Endpoint, time.Since(start), len(resp.Body))
+		}
+		return resp, err
+	}
+}
+
+// RetryInterceptor 传输错误时重试非变更请求,attempts为最多重试次数,backoff为首次重试间隔(之后逐次翻倍)
+// 变更请求可能已在设备上生效,不重试
+func RetryInterceptor(attempts int, backoff time.Duration) Interceptor {
+	return func(req *Request, next Handler) (*Response, error) {
+		resp, err := next(req)
+		if req.Mutating() {
+			return resp, err
+		}
+		wait := backoff
+		for i := 1; i <= attempts && err != nil; i++ {
+			ctx := req.Context
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			t := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return nil, err
+			case <-t.C:
+			}
+			wait *= 2
+			r := *req
+			r.Attempt = i
+			resp, err = next(&r)
+		}
+		return resp, err
+	}
+}
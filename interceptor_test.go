@@ -0,0 +1,90 @@
+package sangfor
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// orderMetrics 记录指标回调的顺序
+type orderMetrics struct {
+	order *[]string
+}
+
+func (m orderMetrics) ObserveRequest(req *Request, d time.Duration, code int, err error) {
+	*m.order = append(*m.order, "observe")
+}
+
+func (m orderMetrics) ObserveRetry(req *Request) {}
+
+func orderInterceptor(name string, order *[]string) Interceptor {
+	return func(req *Request, next Handler) (*Response, error) {
+		*order = append(*order, name+">")
+		resp, err := next(req)
+		*order = append(*order, name+"<")
+		return resp, err
+	}
+}
+
+// TestInterceptorOrder 先添加的拦截器位于外层,指标与审计位于最内层
+func TestInterceptorOrder(t *testing.T) {
+	ac, d := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		return "ok", nil
+	})
+	var order []string
+	ac.Metrics = orderMetrics{&order}
+	ac.Audit = AuditSinkFunc(func(rec *AuditRecord) error {
+		order = append(order, "audit")
+		return nil
+	})
+	ac.Use(orderInterceptor("a", &order))
+	ac.Use(orderInterceptor("b", &order))
+	if _, err := ac.UserDel("x"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a>", "b>", "audit", "observe", "b<", "a<"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+
+	// 直接返回响应的拦截器不会发出请求,也不产生指标与审计
+	order = nil
+	c := ac.WithContext(context.Background())
+	c.Use(func(req *Request, next Handler) (*Response, error) {
+		return &Response{StatusCode: http.StatusOK, Body: []byte(`{"code":0,"data":"AC13.0.15.097"}`)}, nil
+	})
+	if v, err := c.GetVersion(); err != nil || v != "AC13.0.15.097" {
+		t.Fatalf("version = %q, err = %v", v, err)
+	}
+	if want := []string{"a>", "b>", "b<", "a<"}; !reflect.DeepEqual(order, want) || len(d.calls) != 1 {
+		t.Fatalf("order = %v, calls = %v, want no request", order, d.calls)
+	}
+
+	// 副本添加的拦截器不影响原AC
+	order = nil
+	if _, err := ac.GetVersion(); err != nil || len(d.calls) != 2 {
+		t.Fatalf("err = %v, calls = %v, want the original AC to reach the device", err, d.calls)
+	}
+}
+
+// TestLoggingInterceptor 日志只包含操作,接口与耗时,不包含请求数据
+func TestLoggingInterceptor(t *testing.T) {
+	ac, _ := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		return "ok", nil
+	})
+	var buf bytes.Buffer
+	ac.Use(LoggingInterceptor(log.New(&buf, "", 0)))
+	user := UserAdd{Name: "alice"}
+	user.SelfPass.Enable, user.SelfPass.Password = true, "s3cret-pass"
+	if _, err := ac.UserAdd(user); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "ac POST user ") || strings.Contains(out, "s3cret-pass") || strings.Contains(out, "alice") {
+		t.Fatalf("log = %q", out)
+	}
+}
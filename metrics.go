@@ -0,0 +1,227 @@
+/**
+ * @Description: client side metrics with prometheus text exposition
+ * @File:  metrics
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics 客户端指标接口,按接口路径(如 status/version,user,online-users)统计
+type Metrics interface {
+	// ObserveRequest 记录一次实际发出的请求,code为AC返回码,err为传输错误
+	ObserveRequest(req *Request, d time.Duration, code int, err error)
+	// ObserveRetry 记录一次重试
+	ObserveRetry(req *Request)
+}
+
+// observe 记录请求指标,未设置 Metrics 时直接发送
+func (ac *AC) observe(req *Request, next Handler) (*Response, error) {
+	if ac.Metrics == nil {
+		return next(req)
+	}
+	if req.Attempt > 0 {
+		ac.Metrics.ObserveRetry(req)
+	}
+	start := time.Now()
+	resp, err := next(req)
+	code := 0
+	if err == nil {
+		var r acResp
+		if json.Unmarshal(resp.Body, &r) == nil {
+			code = r.Code
+		}
+	}
+	ac.Metrics.ObserveRequest(req, time.Since(start), code, err)
+	return resp, err
+}
+
+// DefaultLatencyBuckets 默认请求耗时分布区间(秒)
+var DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 20}
+
+// PrometheusMetrics 以Prometheus文本格式输出的 Metrics 实现,可直接作为 http.Handler 挂载到/metrics
+type PrometheusMetrics struct {
+	mu        sync.Mutex
+	buckets   []float64
+	requests  map[acMetricKey]int64
+	errors    map[acMetricKey]int64 // 非0返回码,key包含code
+	transport map[acMetricKey]int64
+	retries   map[acMetricKey]int64
+	latency   map[acMetricKey]*acHistogram
+}
+
+type acMetricKey struct {
+	target, endpoint, method, code string
+}
+
+type acHistogram struct {
+	counts []int64 // 与buckets一一对应(非累计)
+	count  int64
+	sum    float64
+}
+
+// NewPrometheusMetrics 创建指标,buckets为耗时分布区间(秒),为空时使用 DefaultLatencyBuckets
+func NewPrometheusMetrics(buckets ...float64) *PrometheusMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &PrometheusMetrics{
+		buckets:   b,
+		requests:  make(map[acMetricKey]int64),
+		errors:    make(map[acMetricKey]int64),
+		transport: make(map[acMetricKey]int64),
+		retries:   make(map[acMetricKey]int64),
+		latency:   make(map[acMetricKey]*acHistogram),
+	}
+}
+
+func acMetricKeyOf(req *Request) acMetricKey {
+	return acMetricKey{target: req.Target, endpoint: req.Endpoint, method: req.Operation()}
+}
+
+func (m *PrometheusMetrics) ObserveRequest(req *Request, d time.Duration, code int, err error) {
+	key := acMetricKeyOf(req)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[key]++
+	h, ok := m.latency[key]
+	if !ok {
+		h = &acHistogram{counts: make([]int64, len(m.buckets))}
+		m.latency[key] = h
+	}
+	sec := d.Seconds()
+	h.count++
+	h.sum += sec
+	for i, b := range m.buckets {
+		if sec <= b {
+			h.counts[i]++
+			break
+		}
+	}
+	switch {
+	case err != nil:
+		m.transport[key]++
+	case code != 0:
+		ek := key
+		ek.code = strconv.Itoa(code)
+		m.errors[ek]++
+	}
+}
+
+func (m *PrometheusMetrics) ObserveRetry(req *Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries[acMetricKeyOf(req)]++
+}
+
+// WriteTo 以Prometheus文本格式输出所有指标
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cw := &acCountWriter{w: bufio.NewWriter(w)}
+	m.writeCounter(cw, "sangfor_ac_requests_total", "Requests sent to the AC.", m.requests)
+	m.writeCounter(cw, "sangfor_ac_result_errors_total", "Responses with a non-zero AC result code.", m.errors)
+	m.writeCounter(cw, "sangfor_ac_transport_errors_total", "Requests that failed before a response was read.", m.transport)
+	m.writeCounter(cw, "sangfor_ac_retries_total", "Retried requests.", m.retries)
+
+	fmt.Fprintf(cw, "# HELP sangfor_ac_request_duration_seconds Request latency.\n# TYPE sangfor_ac_request_duration_seconds histogram\n")
+	for _, k := range acSortedKeys(m.latency) {
+		var (
+			h      = m.latency[k]
+			labels = k.labels()
+			cum    int64
+		)
+		for i, b := range m.buckets {
+			cum += h.counts[i]
+			fmt.Fprintf(cw, "sangfor_ac_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, strconv.FormatFloat(b, 'g', -1, 64), cum)
+		}
+		fmt.Fprintf(cw, "sangfor_ac_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(cw, "sangfor_ac_request_duration_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(cw, "sangfor_ac_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+	if err := cw.w.Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, cw.err
+}
+
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+func (m *PrometheusMetrics) writeCounter(w io.Writer, name, help string, values map[acMetricKey]int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	keys := make([]acMetricKey, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	acSortMetricKeys(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s} %d\n", name, k.labels(), values[k])
+	}
+}
+
+func (k acMetricKey) labels() string {
+	esc := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	l := fmt.Sprintf(`target="%s",endpoint="%s",method="%s"`, esc.Replace(k.target), esc.Replace(k.endpoint), esc.Replace(k.method))
+	if k.code != "" {
+		l += `,code="` + k.code + `"`
+	}
+	return l
+}
+
+func acSortedKeys(m map[acMetricKey]*acHistogram) []acMetricKey {
+	keys := make([]acMetricKey, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	acSortMetricKeys(keys)
+	return keys
+}
+
+func acSortMetricKeys(keys []acMetricKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.target != b.target {
+			return a.target < b.target
+		}
+		if a.endpoint != b.endpoint {
+			return a.endpoint < b.endpoint
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.code < b.code
+	})
+}
+
+type acCountWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *acCountWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
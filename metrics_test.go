@@ -0,0 +1,111 @@
+package sangfor
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPrometheusMetrics 计数器与耗时分布的文本输出
+func TestPrometheusMetrics(t *testing.T) {
+	m := NewPrometheusMetrics(1, 0.1)
+	get := &Request{Target: "ac1", Endpoint: "status/version", Method: "GET"}
+	del := &Request{Target: "ac1", Endpoint: "user", Method: "POST", Query: map[string]string{"_method": "DELETE"}}
+	m.ObserveRequest(get, 50*time.Millisecond, 0, nil)
+	m.ObserveRequest(get, 500*time.Millisecond, 0, nil)
+	m.ObserveRequest(get, 2*time.Second, 0, errors.New("timeout"))
+	m.ObserveRetry(get)
+	m.ObserveRequest(del, 50*time.Millisecond, 2, nil)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("content type = %q", ct)
+	}
+	out := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE sangfor_ac_requests_total counter",
+		`sangfor_ac_requests_total{target="ac1",endpoint="status/version",method="GET"} 3`,
+		`sangfor_ac_requests_total{target="ac1",endpoint="user",method="DELETE"} 1`,
+		`sangfor_ac_result_errors_total{target="ac1",endpoint="user",method="DELETE",code="2"} 1`,
+		`sangfor_ac_transport_errors_total{target="ac1",endpoint="status/version",method="GET"} 1`,
+		`sangfor_ac_retries_total{target="ac1",endpoint="status/version",method="GET"} 1`,
+		"# TYPE sangfor_ac_request_duration_seconds histogram",
+		`sangfor_ac_request_duration_seconds_bucket{target="ac1",endpoint="status/version",method="GET",le="0.1"} 1`,
+		`sangfor_ac_request_duration_seconds_bucket{target="ac1",endpoint="status/version",method="GET",le="1"} 2`,
+		`sangfor_ac_request_duration_seconds_bucket{target="ac1",endpoint="status/version",method="GET",le="+Inf"} 3`,
+		`sangfor_ac_request_duration_seconds_sum{target="ac1",endpoint="status/version",method="GET"} 2.55`,
+		`sangfor_ac_request_duration_seconds_count{target="ac1",endpoint="status/version",method="GET"} 3`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Fatalf("missing %q in\n%s", line, out)
+		}
+	}
+	// 按标签排序输出
+	if strings.Index(out, `endpoint="status/version",method="GET"} 3`) > strings.Index(out, `endpoint="user",method="DELETE"} 1`) {
+		t.Fatalf("counters not sorted:\n%s", out)
+	}
+}
+
+// newFlakyAC 前fail次请求直接断开连接,之后正常返回
+func newFlakyAC(t *testing.T, fail int) (*AC, *int) {
+	t.Helper()
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls <= fail {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		w.Write([]byte(`{"code":0,"data":"ok"}`))
+	}))
+	t.Cleanup(srv.Close)
+	return NewAC(strings.TrimPrefix(srv.URL, "http://"), "secret"), &calls
+}
+
+// TestRetryInterceptor 只读请求按次数重试传输错误,并记录重试指标
+func TestRetryInterceptor(t *testing.T) {
+	ac, calls := newFlakyAC(t, 2)
+	m := NewPrometheusMetrics()
+	ac.Metrics = m
+	ac.Use(RetryInterceptor(3, time.Millisecond))
+	if _, err := ac.GetVersion(); err != nil {
+		t.Fatal(err)
+	}
+	if *calls != 3 {
+		t.Fatalf("calls = %d, want 3", *calls)
+	}
+	key := acMetricKey{target: ac.target(), endpoint: acStatusVersion, method: "GET"}
+	if m.retries[key] != 2 || m.requests[key] != 3 || m.transport[key] != 2 {
+		t.Fatalf("retries = %d, requests = %d, transport = %d", m.retries[key], m.requests[key], m.transport[key])
+	}
+
+	// 超过重试次数时返回最后一次的错误
+	ac, calls = newFlakyAC(t, 10)
+	ac.Use(RetryInterceptor(2, time.Millisecond))
+	if _, err := ac.GetVersion(); err == nil || *calls != 3 {
+		t.Fatalf("err = %v, calls = %d, want error after 3 calls", err, *calls)
+	}
+}
+
+// TestRetryInterceptorMutating 修改类请求(包括POST+_method)不重试
+func TestRetryInterceptorMutating(t *testing.T) {
+	ac, calls := newFlakyAC(t, 1)
+	ac.Use(RetryInterceptor(3, time.Millisecond))
+	if _, err := ac.UserDel("x"); err == nil || *calls != 1 {
+		t.Fatalf("err = %v, calls = %d, want one failed call", err, *calls)
+	}
+	// POST+_method=GET 为查询,可以重试
+	ac, calls = newFlakyAC(t, 1)
+	ac.Use(RetryInterceptor(3, time.Millisecond))
+	ac.UserGet("x")
+	if *calls != 2 {
+		t.Fatalf("calls = %d, want a retry", *calls)
+	}
+}
@@ -0,0 +1,191 @@
+/**
+ * @Description: policy name validation before assignment
+ * @File:  policycheck
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 策略校验失败原因
+const (
+	PolicyUnknown  = "unknown"
+	PolicyDisabled = "disabled"
+	PolicyExpired  = "expired"
+)
+
+// PolicyNameError 策略名校验失败
+type PolicyNameError struct {
+	Kind        string   // net:上网策略 flux:流控策略
+	Name        string   // 策略名
+	Reason      string   // PolicyUnknown/PolicyDisabled/PolicyExpired
+	Suggestions []string // 相近的策略名(仅 PolicyUnknown)
+}
+
+func (e *PolicyNameError) Error() string {
+	msg := fmt.Sprintf("%s %s policy %q", e.Reason, e.Kind, e.Name)
+	if len(e.Suggestions) > 0 {
+		quoted := make([]string, len(e.Suggestions))
+		for i, s := range e.Suggestions {
+			quoted[i] = strconv.Quote(s)
+		}
+		msg += ", did you mean " + strings.Join(quoted, ", ") + "?"
+	}
+	if e.Reason != PolicyUnknown {
+		msg += " (set Force to assign anyway)"
+	}
+	return msg
+}
+
+// checkNetPolicies 校验上网策略名,del操作只校验策略是否存在
+func (ac *AC) checkNetPolicies(opr string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	policies, err := ac.PolicyNetGet()
+	if err != nil {
+		return fmt.Errorf("check net policy: %w", err)
+	}
+	var (
+		all = make([]string, 0, len(policies))
+		idx = make(map[string]NetPolicyInfo, len(policies))
+	)
+	for _, p := range policies {
+		all = append(all, p.PolicyInfo.Name)
+		idx[p.PolicyInfo.Name] = p.PolicyInfo
+	}
+	for _, name := range names {
+		info, ok := idx[name]
+		switch {
+		case !ok:
+			return &PolicyNameError{Kind: "net", Name: name, Reason: PolicyUnknown, Suggestions: acSuggest(name, all)}
+		case opr == "del":
+		case !info.Status:
+			return &PolicyNameError{Kind: "net", Name: name, Reason: PolicyDisabled}
+		case ac.policyExpired(info.Expire):
+			return &PolicyNameError{Kind: "net", Name: name, Reason: PolicyExpired}
+		}
+	}
+	return nil
+}
+
+// checkFluxPolicies 校验流控策略名,del操作只校验策略是否存在
+func (ac *AC) checkFluxPolicies(opr string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	policies, err := ac.PolicyFluxGet()
+	if err != nil {
+		return fmt.Errorf("check flux policy: %w", err)
+	}
+	var (
+		all = make([]string, 0, len(policies))
+		idx = make(map[string]FluxPolicy, len(policies))
+	)
+	for _, p := range policies {
+		all = append(all, p.Name)
+		idx[p.Name] = p
+	}
+	for _, name := range names {
+		p, ok := idx[name]
+		switch {
+		case !ok:
+			return &PolicyNameError{Kind: "flux", Name: name, Reason: PolicyUnknown, Suggestions: acSuggest(name, all)}
+		case opr == "del":
+		case !p.Status:
+			return &PolicyNameError{Kind: "flux", Name: name, Reason: PolicyDisabled}
+		}
+	}
+	return nil
+}
+
+// policyExpired 策略过期时间是否早于设备当前时间,无法解析的值(如永不过期)视为未过期
+func (ac *AC) policyExpired(expire string) bool {
+	return acPolicyExpired(expire, ac.location(), ac.ClockOffset())
+}
+
+// acPolicyExpired 按设备时区loc及时钟偏移offset判断策略是否过期
+func acPolicyExpired(expire string, loc *time.Location, offset time.Duration) bool {
+	if expire == "" {
+		return false
+	}
+	t, err := acParseDeviceTime(expire, loc, offset)
+	if err != nil {
+		return false
+	}
+	if len(expire) == len(acDateLayout) {
+		t = t.AddDate(0, 0, 1) // 只有日期时按该日结束计算
+	}
+	return !time.Now().Before(t)
+}
+
+// acSuggest 返回与name编辑距离最近的至多3个候选
+func acSuggest(name string, candidates []string) []string {
+	type scored struct {
+		name string
+		dist int
+	}
+	var (
+		list  []scored
+		lower = strings.ToLower(name)
+		limit = len([]rune(name)) / 3
+	)
+	if limit < 2 {
+		limit = 2
+	}
+	for _, c := range candidates {
+		d := acLevenshtein(lower, strings.ToLower(c))
+		if d <= limit {
+			list = append(list, scored{c, d})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].dist != list[j].dist {
+			return list[i].dist < list[j].dist
+		}
+		return list[i].name < list[j].name
+	})
+	var r []string
+	for i := 0; i < len(list) && i < 3; i++ {
+		r = append(r, list[i].name)
+	}
+	return r
+}
+
+// acLevenshtein 按字符(rune)计算编辑距离
+func acLevenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = acMin(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func acMin(v int, vs ...int) int {
+	for _, x := range vs {
+		if x < v {
+			v = x
+		}
+	}
+	return v
+}
@@ -0,0 +1,155 @@
+package sangfor
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAcLevenshtein(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+		{"guest", "guest", 0},
+		{"访客策略", "访客策略2", 1},
+		{"上网策略", "上网规则", 2},
+	}
+	for _, c := range cases {
+		if d := acLevenshtein(c.a, c.b); d != c.want {
+			t.Fatalf("acLevenshtein(%q, %q) = %d, want %d", c.a, c.b, d, c.want)
+		}
+	}
+}
+
+func TestAcSuggest(t *testing.T) {
+	all := []string{"Guest", "guest-2", "guests", "staff", "gust", "vip"}
+	// 忽略大小写,按距离及名称排序,至多3个
+	if got, want := acSuggest("guest", all), []string{"Guest", "guests", "gust"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("suggest = %v, want %v", got, want)
+	}
+	if got := acSuggest("zzzzz", all); got != nil {
+		t.Fatalf("suggest = %v, want none", got)
+	}
+}
+
+func TestPolicyNameError(t *testing.T) {
+	cases := []struct {
+		err  *PolicyNameError
+		want string
+	}{
+		{&PolicyNameError{Kind: "net", Name: "gust", Reason: PolicyUnknown, Suggestions: []string{"guest", "Guest"}},
+			`unknown net policy "gust", did you mean "guest", "Guest"?`},
+		{&PolicyNameError{Kind: "net", Name: "x", Reason: PolicyUnknown}, `unknown net policy "x"`},
+		{&PolicyNameError{Kind: "flux", Name: "f", Reason: PolicyDisabled},
+			`disabled flux policy "f" (set Force to assign anyway)`},
+		{&PolicyNameError{Kind: "net", Name: "old", Reason: PolicyExpired},
+			`expired net policy "old" (set Force to assign anyway)`},
+	}
+	for _, c := range cases {
+		if got := c.err.Error(); got != c.want {
+			t.Fatalf("Error() = %s, want %s", got, c.want)
+		}
+	}
+}
+
+// policyCheckDevice 上网策略guest,off(禁用),old(已过期),流控策略limit,stopped(禁用)
+func policyCheckDevice(t *testing.T, check bool) (*AC, *acTestDevice) {
+	past := time.Now().AddDate(0, 0, -2).Format(acDateLayout)
+	ac, d := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		switch endpoint {
+		case acNetPolicy:
+			return []interface{}{
+				map[string]interface{}{"policy_info": map[string]interface{}{"name": "guest", "status": true, "expire": "2099-01-01"}},
+				map[string]interface{}{"policy_info": map[string]interface{}{"name": "off", "status": false}},
+				map[string]interface{}{"policy_info": map[string]interface{}{"name": "old", "status": true, "expire": past}},
+			}, nil
+		case acFluxPolicy:
+			return []interface{}{
+				map[string]interface{}{"id": "1", "name": "limit", "status": true},
+				map[string]interface{}{"id": "2", "name": "stopped", "status": false},
+			}, nil
+		case acUserNetPolicy, acUserFluxPolicy, acGroupNetPolicy:
+			return "ok", nil
+		}
+		return nil, errors.New("unexpected request")
+	})
+	ac.Location, ac.CheckPolicies = time.Local, check
+	return ac, d
+}
+
+func TestCheckPolicies(t *testing.T) {
+	ac, d := policyCheckDevice(t, true)
+	net := func(opr, name string, force bool) error {
+		_, err := ac.UserNetPolicySet(UserPolicySet{Opr: opr, User: "a", Policy: []string{name}, Force: force})
+		return err
+	}
+	cases := []struct {
+		name   string
+		err    error
+		reason string
+	}{
+		{"known", net("add", "guest", false), ""},
+		{"unknown", net("add", "gust", false), PolicyUnknown},
+		{"disabled", net("add", "off", false), PolicyDisabled},
+		{"expired", net("modify", "old", false), PolicyExpired},
+		{"del disabled", net("del", "off", false), ""},
+		{"del expired", net("del", "old", false), ""},
+		{"del unknown", net("del", "gust", false), PolicyUnknown},
+		{"force", net("add", "off", true), ""},
+		{"group disabled", func() error {
+			_, err := ac.GroupNetPolicySet(GroupPolicySet{Opr: "add", Group: "/g", Policy: []string{"off"}})
+			return err
+		}(), PolicyDisabled},
+		{"flux disabled", func() error {
+			_, err := ac.UserFluxPolicySet(UserPolicySet{Opr: "add", User: "a", Policy: []string{"stopped"}})
+			return err
+		}(), PolicyDisabled},
+		{"flux del disabled", func() error {
+			_, err := ac.UserFluxPolicySet(UserPolicySet{Opr: "del", User: "a", Policy: []string{"stopped"}})
+			return err
+		}(), ""},
+	}
+	for _, c := range cases {
+		var pe *PolicyNameError
+		switch {
+		case c.reason == "" && c.err != nil:
+			t.Fatalf("%s: %v", c.name, c.err)
+		case c.reason != "" && (!errors.As(c.err, &pe) || pe.Reason != c.reason):
+			t.Fatalf("%s: err = %v, want %s", c.name, c.err, c.reason)
+		}
+	}
+	var pe *PolicyNameError
+	if err := net("add", "gust", false); !errors.As(err, &pe) || !reflect.DeepEqual(pe.Suggestions, []string{"guest"}) {
+		t.Fatalf("err = %v, want suggestion guest", err)
+	}
+
+	// 未开启校验时不查询策略列表
+	ac, d = policyCheckDevice(t, false)
+	if _, err := ac.UserNetPolicySet(UserPolicySet{Opr: "add", User: "a", Policy: []string{"gust"}}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{acUserNetPolicy}; !reflect.DeepEqual(d.calls, want) {
+		t.Fatalf("calls = %v, want %v", d.calls, want)
+	}
+}
+
+// TestCheckPoliciesListError 获取策略列表失败时保留原错误
+func TestCheckPoliciesListError(t *testing.T) {
+	ac, _ := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		return "ok", nil
+	})
+	down := errors.New("transport down")
+	ac.CheckPolicies = true
+	ac.Use(func(req *Request, next Handler) (*Response, error) {
+		return nil, down
+	})
+	if _, err := ac.UserNetPolicySet(UserPolicySet{Opr: "add", User: "a", Policy: []string{"p"}}); !errors.Is(err, down) {
+		t.Fatalf("err = %v, want wrapped transport error", err)
+	}
+}
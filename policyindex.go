@@ -0,0 +1,381 @@
+/**
+ * @Description: index over net/flux policies and their user associations
+ * @File:  policyindex
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 策略关联方式
+const (
+	PolicyViaUser     = "user"      // 直接关联用户(ou中的用户路径或用户详情中的策略)
+	PolicyViaGroup    = "group"     // 关联用户所在组或上级组
+	PolicyViaADUser   = "aduser"    // 关联域用户
+	PolicyViaADGroup  = "adgroup"   // 关联域安全组
+	PolicyViaSourceIP = "source_ip" // 源IP范围包含用户绑定的IP
+	PolicyViaLocation = "location"  // 关联用户所在位置
+	PolicyViaTerminal = "terminal"  // 关联用户终端类型
+)
+
+// PolicyMatch 适用的策略及关联方式
+type PolicyMatch struct {
+	Policy NetPolicyInfo `json:"policy"`
+	Via    string        `json:"via"`    // PolicyViaUser/PolicyViaGroup/...
+	Detail string        `json:"detail"` // 匹配的关联项,如组路径,IP范围
+}
+
+// FluxMatch 适用的流控策略及关联方式
+type FluxMatch struct {
+	Policy FluxPolicy `json:"policy"`
+	Via    string     `json:"via"`    // PolicyViaUser/PolicyViaGroup/PolicyViaSourceIP
+	Detail string     `json:"detail"` // 匹配的适用对象,如组路径,IP范围
+}
+
+// PolicyIndex 上网策略,流控策略与用户关联关系的索引
+type PolicyIndex struct {
+	Net   []NetPolicy
+	Flux  []FluxPolicy
+	Users []UserDetail
+
+	// Truncated 搜索结果达到接口上限(100个)的组,这些组的用户不完整,
+	// UsersWithoutPolicy 等按用户的结果可能有遗漏,可按子组拆分后重新建立索引
+	Truncated []string
+
+	ranges     []acPolicyRange // 上网策略的源IP范围
+	fluxRanges []acPolicyRange // 流控策略适用对象中的IP范围
+	byName     map[string]*UserDetail
+	direct     map[string][]string // 用户名 -> 用户详情中直接关联的策略名
+}
+
+type acPolicyRange struct {
+	policy int
+	raw    string
+	r      IPRange
+}
+
+// BuildPolicyIndex 获取上网策略,流控策略及groups中的用户(每组最多100个)建立索引
+// groups为空时搜索"/",搜索结果达到上限的组记录在 PolicyIndex.Truncated
+func (ac *AC) BuildPolicyIndex(groups ...string) (*PolicyIndex, error) {
+	if len(groups) == 0 {
+		groups = []string{"/"}
+	}
+	var (
+		users     []UserDetail
+		truncated []string
+		seen      = make(map[string]bool)
+	)
+	for _, g := range groups {
+		found, err := ac.UserSearch(SearchByName("", InGroup(g)))
+		if err != nil {
+			return nil, fmt.Errorf("search group %s: %w", g, err)
+		}
+		if len(found) >= acUserSearchLimit {
+			truncated = append(truncated, g)
+		}
+		for _, u := range found {
+			if !seen[u.Name] {
+				seen[u.Name] = true
+				users = append(users, u)
+			}
+		}
+	}
+	idx, err := ac.policyIndex(users)
+	if err != nil {
+		return nil, err
+	}
+	idx.Truncated = truncated
+	return idx, nil
+}
+
+// policyIndex 获取上网策略与流控策略,与users建立索引
+func (ac *AC) policyIndex(users []UserDetail) (*PolicyIndex, error) {
+	netPolicies, err := ac.PolicyNetGet()
+	if err != nil {
+		return nil, fmt.Errorf("get net policy: %w", err)
+	}
+	fluxPolicies, err := ac.PolicyFluxGet()
+	if err != nil {
+		return nil, fmt.Errorf("get flux policy: %w", err)
+	}
+	return NewPolicyIndex(netPolicies, fluxPolicies, users), nil
+}
+
+// NewPolicyIndex 由已获取的策略和用户建立索引
+func NewPolicyIndex(netPolicies []NetPolicy, fluxPolicies []FluxPolicy, users []UserDetail) *PolicyIndex {
+	idx := &PolicyIndex{
+		Net:    netPolicies,
+		Flux:   fluxPolicies,
+		Users:  users,
+		byName: make(map[string]*UserDetail, len(users)),
+		direct: make(map[string][]string),
+	}
+	for i := range users {
+		u := &users[i]
+		idx.byName[u.Name] = u
+		for _, p := range u.Policy {
+			idx.direct[u.Name] = append(idx.direct[u.Name], p.Name)
+		}
+	}
+	for i, p := range netPolicies {
+		for _, s := range p.UserInfo.Sourceip {
+			if r, err := ParseIPRange(s); err == nil {
+				idx.ranges = append(idx.ranges, acPolicyRange{policy: i, raw: s, r: r})
+			}
+		}
+	}
+	for i, p := range fluxPolicies {
+		for _, s := range acSplitList(p.Object) {
+			if r, err := ParseIPRange(s); err == nil {
+				idx.fluxRanges = append(idx.fluxRanges, acPolicyRange{policy: i, raw: s, r: r})
+			}
+		}
+	}
+	return idx
+}
+
+// ForUser 返回适用于用户的上网策略,用户不在索引中时只按用户名匹配
+func (idx *PolicyIndex) ForUser(name string) []PolicyMatch {
+	var (
+		r    acMatchSet
+		user = idx.byName[name]
+	)
+	for _, pname := range idx.direct[name] {
+		if i := idx.netIndex(pname); i >= 0 {
+			r.add(idx.Net[i].PolicyInfo, PolicyViaUser, name)
+		}
+	}
+	for _, p := range idx.Net {
+		for _, ou := range p.UserInfo.Ou {
+			switch {
+			case ou == name || (user != nil && ou == acUserPath(user)):
+				r.add(p.PolicyInfo, PolicyViaUser, ou)
+			case user != nil && acInGroup(user.FatherPath, ou):
+				r.add(p.PolicyInfo, PolicyViaGroup, ou)
+			}
+		}
+		for _, ad := range p.UserInfo.Aduser {
+			if ad == name {
+				r.add(p.PolicyInfo, PolicyViaADUser, ad)
+			}
+		}
+	}
+	if user != nil {
+		for _, b := range user.BindCfg {
+			if ip := net.ParseIP(b["ip"]); ip != nil {
+				for _, m := range idx.ForIP(ip) {
+					r.add(m.Policy, m.Via, m.Detail)
+				}
+			}
+		}
+	}
+	return r.list
+}
+
+// ForGroup 返回关联到组(包括从上级组继承)及同名域安全组的上网策略
+func (idx *PolicyIndex) ForGroup(path string) []PolicyMatch {
+	var r acMatchSet
+	for _, p := range idx.Net {
+		for _, ou := range p.UserInfo.Ou {
+			if strings.HasPrefix(ou, "/") && acInGroup(path, ou) && idx.byPath(ou) == nil {
+				r.add(p.PolicyInfo, PolicyViaGroup, ou)
+			}
+		}
+		for _, g := range p.UserInfo.Adgroup {
+			if g == path {
+				r.add(p.PolicyInfo, PolicyViaADGroup, g)
+			}
+		}
+	}
+	return r.list
+}
+
+// ForIP 返回源IP范围包含ip的上网策略
+func (idx *PolicyIndex) ForIP(ip net.IP) []PolicyMatch {
+	var r acMatchSet
+	for _, pr := range idx.ranges {
+		if pr.r.Contains(ip) {
+			r.add(idx.Net[pr.policy].PolicyInfo, PolicyViaSourceIP, pr.raw)
+		}
+	}
+	return r.list
+}
+
+// FluxForUser 返回适用于用户的流控策略(适用对象为用户名,用户路径,所在组或上级组,绑定的IP)
+func (idx *PolicyIndex) FluxForUser(name string) []FluxMatch {
+	var (
+		r    acFluxMatchSet
+		user = idx.byName[name]
+	)
+	for _, p := range idx.Flux {
+		for _, obj := range acSplitList(p.Object) {
+			switch {
+			case obj == name || (user != nil && obj == acUserPath(user)):
+				r.add(p, PolicyViaUser, obj)
+			case user != nil && strings.HasPrefix(obj, "/") && acInGroup(user.FatherPath, obj):
+				r.add(p, PolicyViaGroup, obj)
+			}
+		}
+	}
+	if user != nil {
+		for _, b := range user.BindCfg {
+			if ip := net.ParseIP(b["ip"]); ip != nil {
+				for _, m := range idx.FluxForIP(ip) {
+					r.add(m.Policy, m.Via, m.Detail)
+				}
+			}
+		}
+	}
+	return r.list
+}
+
+// FluxForGroup 返回适用对象为该组或其上级组的流控策略
+func (idx *PolicyIndex) FluxForGroup(path string) []FluxMatch {
+	var r acFluxMatchSet
+	for _, p := range idx.Flux {
+		for _, obj := range acSplitList(p.Object) {
+			if strings.HasPrefix(obj, "/") && acInGroup(path, obj) && idx.byPath(obj) == nil {
+				r.add(p, PolicyViaGroup, obj)
+			}
+		}
+	}
+	return r.list
+}
+
+// FluxForIP 返回适用对象中的IP范围包含ip的流控策略
+func (idx *PolicyIndex) FluxForIP(ip net.IP) []FluxMatch {
+	var r acFluxMatchSet
+	for _, pr := range idx.fluxRanges {
+		if pr.r.Contains(ip) {
+			r.add(idx.Flux[pr.policy], PolicyViaSourceIP, pr.raw)
+		}
+	}
+	return r.list
+}
+
+// Disabled 返回已禁用的上网策略与流控策略
+func (idx *PolicyIndex) Disabled() ([]NetPolicyInfo, []FluxPolicy) {
+	var (
+		netPolicies  []NetPolicyInfo
+		fluxPolicies []FluxPolicy
+	)
+	for _, p := range idx.Net {
+		if !p.PolicyInfo.Status {
+			netPolicies = append(netPolicies, p.PolicyInfo)
+		}
+	}
+	for _, p := range idx.Flux {
+		if !p.Status {
+			fluxPolicies = append(fluxPolicies, p)
+		}
+	}
+	return netPolicies, fluxPolicies
+}
+
+// Expired 返回已过期的上网策略,过期时间按设备时区loc(为nil时为本地时区)解析并扣除时钟偏移offset
+// e.g: idx.Expired(ac.Location, ac.ClockOffset())
+func (idx *PolicyIndex) Expired(loc *time.Location, offset time.Duration) []NetPolicyInfo {
+	if loc == nil {
+		loc = time.Local
+	}
+	var r []NetPolicyInfo
+	for _, p := range idx.Net {
+		if acPolicyExpired(p.PolicyInfo.Expire, loc, offset) {
+			r = append(r, p.PolicyInfo)
+		}
+	}
+	return r
+}
+
+// UsersWithoutPolicy 返回没有任何适用上网策略的用户,Truncated 不为空时结果不完整
+func (idx *PolicyIndex) UsersWithoutPolicy() []UserDetail {
+	var r []UserDetail
+	for _, u := range idx.Users {
+		if len(idx.ForUser(u.Name)) == 0 {
+			r = append(r, u)
+		}
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Name < r[j].Name })
+	return r
+}
+
+func (idx *PolicyIndex) netIndex(name string) int {
+	for i, p := range idx.Net {
+		if p.PolicyInfo.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// byPath 按用户路径(所在组/用户名)查找用户
+func (idx *PolicyIndex) byPath(path string) *UserDetail {
+	i := strings.LastIndex(path, "/")
+	if i < 0 {
+		return nil
+	}
+	u := idx.byName[path[i+1:]]
+	if u != nil && acUserPath(u) == path {
+		return u
+	}
+	return nil
+}
+
+// acUserPath 用户的完整路径,e.g:/研发部/张三
+func acUserPath(u *UserDetail) string {
+	return strings.TrimSuffix(u.FatherPath, "/") + "/" + u.Name
+}
+
+// acMatchSet 按策略名与关联方式去重
+type acMatchSet struct {
+	list []PolicyMatch
+	seen map[string]bool
+}
+
+func (s *acMatchSet) add(p NetPolicyInfo, via, detail string) {
+	if s.seen == nil {
+		s.seen = make(map[string]bool)
+	}
+	key := p.Name + "\x00" + via + "\x00" + detail
+	if s.seen[key] {
+		return
+	}
+	s.seen[key] = true
+	s.list = append(s.list, PolicyMatch{Policy: p, Via: via, Detail: detail})
+}
+
+// acFluxMatchSet 按流控策略名与关联方式去重
+type acFluxMatchSet struct {
+	list []FluxMatch
+	seen map[string]bool
+}
+
+func (s *acFluxMatchSet) add(p FluxPolicy, via, detail string) {
+	if s.seen == nil {
+		s.seen = make(map[string]bool)
+	}
+	key := p.Name + "\x00" + via + "\x00" + detail
+	if s.seen[key] {
+		return
+	}
+	s.seen[key] = true
+	s.list = append(s.list, FluxMatch{Policy: p, Via: via, Detail: detail})
+}
+
+// acSplitList 拆分逗号分隔的列表
+func acSplitList(s string) []string {
+	var r []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			r = append(r, v)
+		}
+	}
+	return r
+}
@@ -0,0 +1,132 @@
+package sangfor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testPolicyIndex() *PolicyIndex {
+	u := UserDetail{Name: "zhangsan", FatherPath: "/dev/backend"}
+	u.BindCfg = []map[string]string{{"ip": "10.0.0.5"}}
+	u.Policy = []NetPolicyInfo{{Name: "direct"}}
+	var group, ip NetPolicy
+	group.PolicyInfo.Name = "group"
+	group.UserInfo.Ou = []string{"/dev"}
+	ip.PolicyInfo.Name = "ip"
+	ip.UserInfo.Sourceip = []string{"10.0.0.1-10.0.0.10"}
+	return NewPolicyIndex(
+		[]NetPolicy{{PolicyInfo: NetPolicyInfo{Name: "direct"}}, group, ip},
+		[]FluxPolicy{
+			{Name: "flux-user", Object: "/dev/backend/zhangsan"},
+			{Name: "flux-group", Object: "/dev, /ops"},
+			{Name: "flux-ip", Object: "10.0.0.0/24"},
+			{Name: "flux-other", Object: "lisi,/ops"},
+		},
+		[]UserDetail{u},
+	)
+}
+
+func acMatchNames(vias map[string]string) func(name, via string) bool {
+	return func(name, via string) bool {
+		want, ok := vias[name]
+		delete(vias, name)
+		return ok && want == via
+	}
+}
+
+func TestPolicyIndexForUser(t *testing.T) {
+	idx := testPolicyIndex()
+	check := acMatchNames(map[string]string{"direct": PolicyViaUser, "group": PolicyViaGroup, "ip": PolicyViaSourceIP})
+	for _, m := range idx.ForUser("zhangsan") {
+		if !check(m.Policy.Name, m.Via) {
+			t.Fatalf("unexpected net match %+v", m)
+		}
+	}
+	check = acMatchNames(map[string]string{"flux-user": PolicyViaUser, "flux-group": PolicyViaGroup, "flux-ip": PolicyViaSourceIP})
+	matches := idx.FluxForUser("zhangsan")
+	for _, m := range matches {
+		if !check(m.Policy.Name, m.Via) {
+			t.Fatalf("unexpected flux match %+v", m)
+		}
+	}
+	if len(matches) != 3 {
+		t.Fatalf("flux matches = %+v, want 3", matches)
+	}
+}
+
+func TestPolicyIndexFluxLookups(t *testing.T) {
+	idx := testPolicyIndex()
+	if m := idx.FluxForGroup("/ops/a"); len(m) != 2 || m[0].Policy.Name != "flux-group" || m[1].Policy.Name != "flux-other" {
+		t.Fatalf("FluxForGroup = %+v", m)
+	}
+	if m := idx.FluxForIP(net.ParseIP("10.0.0.200")); len(m) != 1 || m[0].Policy.Name != "flux-ip" {
+		t.Fatalf("FluxForIP = %+v", m)
+	}
+	if m := idx.FluxForIP(net.ParseIP("10.0.1.1")); len(m) != 0 {
+		t.Fatalf("FluxForIP = %+v, want none", m)
+	}
+}
+
+func TestPolicyIndexExpired(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	now := time.Now().In(loc)
+	idx := NewPolicyIndex([]NetPolicy{
+		{PolicyInfo: NetPolicyInfo{Name: "yesterday", Expire: now.AddDate(0, 0, -1).Format(acDateLayout)}},
+		{PolicyInfo: NetPolicyInfo{Name: "later", Expire: now.AddDate(0, 0, 2).Format(acDateLayout)}},
+		{PolicyInfo: NetPolicyInfo{Name: "soon", Expire: now.Add(30 * time.Minute).Format(acTimeLayout)}},
+		{PolicyInfo: NetPolicyInfo{Name: "never", Expire: "永不过期"}},
+	}, nil, nil)
+	cases := []struct {
+		name   string
+		offset time.Duration
+		want   int
+	}{
+		{"no offset", 0, 1},
+		{"device ahead an hour", time.Hour, 2},
+	}
+	for _, c := range cases {
+		if got := idx.Expired(loc, c.offset); len(got) != c.want {
+			t.Fatalf("%s: expired = %+v, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+// TestBuildPolicyIndexTruncated 搜索结果达到上限的组记录在Truncated
+func TestBuildPolicyIndexTruncated(t *testing.T) {
+	ac, _ := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		switch endpoint {
+		case acNetPolicy, acFluxPolicy:
+			return []interface{}{}, nil
+		case acUser:
+			var body struct {
+				Extend struct {
+					FatherPath string `json:"father_path"`
+				} `json:"extend"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			n := 3
+			if body.Extend.FatherPath == "/big" {
+				n = acUserSearchLimit
+			}
+			var list []interface{}
+			for i := 0; i < n; i++ {
+				list = append(list, map[string]interface{}{"name": fmt.Sprintf("%s-%d", body.Extend.FatherPath, i)})
+			}
+			return list, nil
+		}
+		return nil, errors.New("unexpected request")
+	})
+	idx, err := ac.BuildPolicyIndex("/small", "/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Users) != 3+acUserSearchLimit || !reflect.DeepEqual(idx.Truncated, []string{"/big"}) {
+		t.Fatalf("users = %d, truncated = %v", len(idx.Users), idx.Truncated)
+	}
+}
@@ -0,0 +1,362 @@
+/**
+ * @Description: bandwidth quota enforcement based on user rank
+ * @File:  quota
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QuotaPeriod 配额周期
+type QuotaPeriod string
+
+const (
+	QuotaDaily   QuotaPeriod = "daily"   // 按自然日统计
+	QuotaMonthly QuotaPeriod = "monthly" // 按自然月统计
+)
+
+// QuotaAction 超出配额后的处理方式
+type QuotaAction string
+
+const (
+	QuotaThrottle QuotaAction = "throttle" // 通过 UserFluxPolicySet 关联限速流控策略
+	QuotaKick     QuotaAction = "kick"     // 通过 OnlineUserKick 强制注销
+	QuotaRestrict QuotaAction = "restrict" // 通过 UserMod 移动到受限组
+)
+
+// Quota 流量配额规则
+type Quota struct {
+	Name          string      `json:"name"`                     // 规则名
+	Period        QuotaPeriod `json:"period"`                   // 统计周期
+	Limit         int64       `json:"limit"`                    // 周期内总流量上限(bytes)
+	Action        QuotaAction `json:"action"`                   // 超限处理方式
+	FluxPolicy    string      `json:"flux_policy,omitempty"`    // 限速流控策略名(throttle)
+	RestrictGroup string      `json:"restrict_group,omitempty"` // 受限组(restrict)
+	Groups        []string    `json:"groups,omitempty"`         // 适用的组(含子组),为空表示所有用户
+	Exempt        []string    `json:"exempt,omitempty"`         // 豁免的用户
+}
+
+// QuotaEnforcement 已执行的超限处理,周期结束时撤销
+type QuotaEnforcement struct {
+	Quota     string      `json:"quota"`
+	Action    QuotaAction `json:"action"`
+	Period    string      `json:"period"`               // 周期标识(e.g:2021-06-01,2021-06)
+	At        time.Time   `json:"at"`                   // 处理时间
+	OrigGroup string      `json:"orig_group,omitempty"` // 移动前的组(restrict)
+}
+
+// QuotaUsage 用户流量累计
+type QuotaUsage struct {
+	User       string             `json:"user"`
+	Group      string             `json:"group"`
+	Ip         string             `json:"ip,omitempty"`
+	Counter    int64              `json:"counter"`     // 最近一次采样的设备计数(bytes)
+	Day        string             `json:"day"`         // 当前日周期标识
+	DayBytes   int64              `json:"day_bytes"`   // 当日累计(bytes)
+	Month      string             `json:"month"`       // 当前月周期标识
+	MonthBytes int64              `json:"month_bytes"` // 当月累计(bytes)
+	Enforced   []QuotaEnforcement `json:"enforced,omitempty"`
+	UpdatedAt  time.Time          `json:"updated_at"`
+}
+
+// QuotaEvent 配额事件(超限处理,撤销,处理失败)
+type QuotaEvent struct {
+	Time   time.Time   `json:"time"`
+	User   string      `json:"user"`
+	Quota  string      `json:"quota"`
+	Action QuotaAction `json:"action"`
+	Revert bool        `json:"revert,omitempty"` // 是否为周期结束时的撤销
+	Usage  int64       `json:"usage"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// QuotaDaemon 流量配额守护
+// 周期采样 GetUserRank,按用户累计日/月流量(设备计数回退时视为计数器重置),
+// 超出配额后执行限速,注销或移动到受限组,并在周期切换时撤销
+type QuotaDaemon struct {
+	AC        *AC
+	Quotas    []Quota
+	StatePath string // 累计流量与处理记录的持久化文件,为空时只保存在内存中
+	Top       int    // 每次采样的用户排行数量,为0时为1000
+	OnEvent   func(ev QuotaEvent)
+
+	mu    sync.Mutex
+	state map[string]*QuotaUsage
+}
+
+// Usage 返回当前所有用户的累计流量
+func (d *QuotaDaemon) Usage() ([]QuotaUsage, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	r := make([]QuotaUsage, 0, len(d.state))
+	for _, u := range d.state {
+		r = append(r, *u)
+	}
+	return r, nil
+}
+
+// Sample 采样一次,累计流量并执行超限处理与周期撤销
+func (d *QuotaDaemon) Sample() error {
+	if d.AC == nil {
+		return acErrArg()
+	}
+	top := d.Top
+	if top <= 0 {
+		top = 1000
+	}
+	ranks, err := d.AC.GetUserRank(UserRankFilter{Top: top})
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err = d.load(); err != nil {
+		return err
+	}
+	var (
+		now   = time.Now()
+		day   = now.Format("2006-01-02")
+		month = now.Format("2006-01")
+		errs  []string
+	)
+	// 先处理所有用户的周期切换,离线用户同样需要撤销
+	for _, u := range d.state {
+		if u.Day != day {
+			u.Day, u.DayBytes = day, 0
+		}
+		if u.Month != month {
+			u.Month, u.MonthBytes = month, 0
+		}
+		errs = append(errs, d.revertExpired(u, day, month)...)
+	}
+	for _, r := range ranks {
+		if r.Name == "" {
+			continue
+		}
+		u, ok := d.state[r.Name]
+		if !ok {
+			u = &QuotaUsage{User: r.Name, Day: day, Month: month}
+			d.state[r.Name] = u
+		}
+		total := int64(r.Total)
+		delta := total - u.Counter
+		if delta < 0 {
+			// 计数器重置(用户重新上线或设备重启)
+			delta = total
+		}
+		if !ok {
+			// 首次采样无法得知本周期之前的流量,只从当前计数开始累计
+			delta = 0
+		}
+		u.Counter, u.Group, u.Ip, u.UpdatedAt = total, r.Group, r.Ip, now
+		u.DayBytes += delta
+		u.MonthBytes += delta
+		errs = append(errs, d.enforce(u, day, month)...)
+	}
+	if err = d.save(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("quota: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Run 按间隔采样,直到ctx结束,单次采样的错误交由onErr处理(可为nil)
+func (d *QuotaDaemon) Run(ctx context.Context, interval time.Duration, onErr func(error)) error {
+	if interval <= 0 {
+		return acErrArg()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := d.Sample(); err != nil && onErr != nil {
+			onErr(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *QuotaDaemon) enforce(u *QuotaUsage, day, month string) []string {
+	var errs []string
+	for _, q := range d.Quotas {
+		if !q.applies(u) {
+			continue
+		}
+		period, usage := day, u.DayBytes
+		if q.Period == QuotaMonthly {
+			period, usage = month, u.MonthBytes
+		}
+		if usage < q.Limit {
+			continue
+		}
+		enforced := false
+		for _, e := range u.Enforced {
+			if e.Quota == q.Name && e.Period == period {
+				enforced = true
+			}
+		}
+		// 注销后用户可能重新上线,超限期间持续注销
+		if enforced && q.Action != QuotaKick {
+			continue
+		}
+		e := QuotaEnforcement{Quota: q.Name, Action: q.Action, Period: period, At: time.Now()}
+		var err error
+		switch q.Action {
+		case QuotaThrottle:
+			_, err = d.AC.UserFluxPolicySet(UserPolicySet{Opr: "add", User: u.User, Policy: []string{q.FluxPolicy}})
+		case QuotaKick:
+			err = d.AC.OnlineUserKick(u.Ip)
+		case QuotaRestrict:
+			e.OrigGroup = u.Group
+			err = d.moveGroup(u.User, q.RestrictGroup)
+		default:
+			err = acArgErrorf("invalid quota action %q", q.Action)
+		}
+		d.event(QuotaEvent{Time: e.At, User: u.User, Quota: q.Name, Action: q.Action, Usage: usage}, err)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s/%s: %v", u.User, q.Name, err))
+			continue
+		}
+		if !enforced {
+			u.Enforced = append(u.Enforced, e)
+		}
+	}
+	return errs
+}
+
+// revertExpired 撤销已结束周期的超限处理,撤销失败的记录保留等待下次重试
+func (d *QuotaDaemon) revertExpired(u *QuotaUsage, day, month string) []string {
+	var (
+		kept = u.Enforced[:0:0]
+		errs []string
+	)
+	for _, e := range u.Enforced {
+		if e.Period == day || e.Period == month {
+			kept = append(kept, e)
+			continue
+		}
+		var err error
+		switch e.Action {
+		case QuotaThrottle:
+			policy := ""
+			for _, q := range d.Quotas {
+				if q.Name == e.Quota {
+					policy = q.FluxPolicy
+				}
+			}
+			if policy != "" {
+				_, err = d.AC.UserFluxPolicySet(UserPolicySet{Opr: "del", User: u.User, Policy: []string{policy}})
+			}
+		case QuotaRestrict:
+			if e.OrigGroup != "" {
+				err = d.moveGroup(u.User, e.OrigGroup)
+			}
+		}
+		d.event(QuotaEvent{Time: time.Now(), User: u.User, Quota: e.Quota, Action: e.Action, Revert: true}, err)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("revert %s/%s: %v", u.User, e.Quota, err))
+			kept = append(kept, e)
+		}
+	}
+	u.Enforced = kept
+	return errs
+}
+
+// moveGroup 通过 UserMod 修改用户所在组
+// UserMod 文档中 extend.father_path 为搜索扩展字段,修改后读取用户确认已移动,未移动时返回错误
+func (d *QuotaDaemon) moveGroup(user, group string) error {
+	if !strings.HasPrefix(group, "/") {
+		return acErrArg()
+	}
+	mod := UserMod{Name: user}
+	mod.Data.Extend.FatherPath = group
+	if _, err := d.AC.UserMod(mod); err != nil {
+		return err
+	}
+	u, err := d.AC.UserGet(user)
+	if err != nil {
+		return fmt.Errorf("verify group of %s: %w", user, err)
+	}
+	if u == nil || u.FatherPath != group {
+		path := ""
+		if u != nil {
+			path = u.FatherPath
+		}
+		return fmt.Errorf("user %s was not moved to %s (in %q)", user, group, path)
+	}
+	return nil
+}
+
+func (d *QuotaDaemon) event(ev QuotaEvent, err error) {
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	if d.OnEvent != nil {
+		d.OnEvent(ev)
+	}
+}
+
+func (d *QuotaDaemon) load() error {
+	if d.state != nil {
+		return nil
+	}
+	d.state = make(map[string]*QuotaUsage)
+	if d.StatePath == "" {
+		return nil
+	}
+	if _, err := acLoadJSON(d.StatePath, &d.state); err != nil {
+		d.state = nil
+		return err
+	}
+	return nil
+}
+
+func (d *QuotaDaemon) save() error {
+	if d.StatePath == "" {
+		return nil
+	}
+	return acSaveJSON(d.StatePath, d.state)
+}
+
+// applies 配额是否适用于该用户
+func (q Quota) applies(u *QuotaUsage) bool {
+	for _, name := range q.Exempt {
+		if name == u.User {
+			return false
+		}
+	}
+	if q.Limit <= 0 {
+		return false
+	}
+	// 已被移动到受限组的用户仍按原组计算
+	group := u.Group
+	for _, e := range u.Enforced {
+		if e.OrigGroup != "" {
+			group = e.OrigGroup
+		}
+	}
+	if len(q.Groups) == 0 {
+		return true
+	}
+	for _, g := range q.Groups {
+		if acInGroup(group, g) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package sangfor
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQuotaMoveGroupVerify(t *testing.T) {
+	cases := []struct {
+		name  string
+		moved bool
+		err   bool
+	}{
+		{"moved", true, false},
+		{"ignored by device", false, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := "/staff"
+			ac, _ := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+				if r.URL.Query().Get("_method") == "PUT" {
+					if c.moved {
+						path = "/restricted"
+					}
+					return "ok", nil
+				}
+				return map[string]interface{}{"name": "a", "father_path": path}, nil
+			})
+			err := (&QuotaDaemon{AC: ac}).moveGroup("a", "/restricted")
+			if (err != nil) != c.err {
+				t.Fatalf("err = %v, want error %v", err, c.err)
+			}
+		})
+	}
+}
+
+// quotaDevice 模拟用户流量排行,流控策略关联及用户所在组,ops记录策略增删与组移动
+type quotaDevice struct {
+	ranks []map[string]interface{}
+	group string
+	ops   []string
+}
+
+func newQuotaDevice(t *testing.T) (*AC, *quotaDevice) {
+	d := &quotaDevice{group: "/staff"}
+	ac, _ := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		switch endpoint {
+		case acStatusUserRank:
+			return d.ranks, nil
+		case acFluxPolicy:
+			return []interface{}{map[string]interface{}{"id": "1", "name": "slow", "father_id": "0", "status": true}}, nil
+		case acUserFluxPolicy:
+			var set UserPolicySet
+			_ = json.NewDecoder(r.Body).Decode(&set)
+			d.ops = append(d.ops, set.Opr+" "+strings.Join(set.Policy, ","))
+			return "ok", nil
+		case acUser:
+			if r.URL.Query().Get("_method") == "PUT" {
+				var mod map[string]map[string]map[string]string
+				_ = json.NewDecoder(r.Body).Decode(&mod)
+				d.group = mod["data"]["extend"]["father_path"]
+				d.ops = append(d.ops, "move "+d.group)
+				return "ok", nil
+			}
+			return map[string]interface{}{"name": "a", "father_path": d.group}, nil
+		}
+		return nil, errors.New("unexpected request")
+	})
+	return ac, d
+}
+
+func (d *quotaDevice) rank(totals ...int) {
+	d.ranks = []map[string]interface{}{
+		{"name": "a", "group": "/staff", "ip": "10.0.0.1", "total": totals[0]},
+		{"name": "b", "group": "/staff", "ip": "10.0.0.2", "total": totals[1]},
+	}
+}
+
+// TestQuotaAccumulateEnforce 按采样差值累计流量(计数回退视为重置),超限后只处理一次,豁免用户不处理
+func TestQuotaAccumulateEnforce(t *testing.T) {
+	ac, dev := newQuotaDevice(t)
+	var events []QuotaEvent
+	d := &QuotaDaemon{AC: ac, StatePath: filepath.Join(t.TempDir(), "quota.json"), OnEvent: func(ev QuotaEvent) {
+		events = append(events, ev)
+	}, Quotas: []Quota{{Name: "daily", Period: QuotaDaily, Limit: 1000, Action: QuotaThrottle, FluxPolicy: "slow",
+		Groups: []string{"/staff"}, Exempt: []string{"b"}}}}
+	for _, totals := range [][]int{{500, 0}, {1200, 5000}, {200, 9000}, {400, 9900}, {500, 9999}} {
+		dev.rank(totals...)
+		if err := d.Sample(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(dev.ops) != 1 || dev.ops[0] != "add slow" || len(events) != 1 || events[0].User != "a" {
+		t.Fatalf("ops = %v, events = %+v, want a throttled once", dev.ops, events)
+	}
+	// 重新加载持久化的累计流量
+	usage, err := (&QuotaDaemon{StatePath: d.StatePath}).Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range usage {
+		if u.User == "a" && (u.DayBytes != 1200 || u.MonthBytes != 1200 || len(u.Enforced) != 1) {
+			t.Fatalf("usage of a = %+v, want 1200 bytes and one enforcement", u)
+		}
+	}
+}
+
+// TestQuotaRevert 周期切换后撤销限速并移回原组,离线用户同样撤销
+func TestQuotaRevert(t *testing.T) {
+	ac, dev := newQuotaDevice(t)
+	dev.group = "/restricted"
+	d := &QuotaDaemon{AC: ac, Quotas: []Quota{
+		{Name: "daily", Period: QuotaDaily, Limit: 1000, Action: QuotaThrottle, FluxPolicy: "slow"},
+		{Name: "monthly", Period: QuotaMonthly, Limit: 1000, Action: QuotaRestrict, RestrictGroup: "/restricted"},
+	}}
+	d.state = map[string]*QuotaUsage{"a": {User: "a", Group: "/restricted", Day: "2000-01-01", Month: "2000-01", DayBytes: 5000, MonthBytes: 5000,
+		Enforced: []QuotaEnforcement{
+			{Quota: "daily", Action: QuotaThrottle, Period: "2000-01-01"},
+			{Quota: "monthly", Action: QuotaRestrict, Period: "2000-01", OrigGroup: "/staff"},
+		}}}
+	dev.ranks = []map[string]interface{}{}
+	if err := d.Sample(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"del slow", "move /staff"}; !reflect.DeepEqual(dev.ops, want) {
+		t.Fatalf("ops = %v, want %v", dev.ops, want)
+	}
+	if u := d.state["a"]; len(u.Enforced) != 0 || u.DayBytes != 0 || u.MonthBytes != 0 {
+		t.Fatalf("usage = %+v, want reset and nothing enforced", u)
+	}
+}
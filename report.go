@@ -0,0 +1,200 @@
+/**
+ * @Description: traffic top-talker reports
+ * @File:  report
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"encoding/csv"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// TrafficPeak 吞吐量峰值
+type TrafficPeak struct {
+	At   time.Time `json:"at"`
+	Peak int64     `json:"peak"` // 收发合计
+	Recv int64     `json:"recv"` // 接收峰值
+	Send int64     `json:"send"` // 发送峰值
+	Unit string    `json:"unit"`
+}
+
+// LineApps 单条线路的应用流量排行
+type LineApps struct {
+	Line     int          `json:"line"`
+	LineName string       `json:"line_name"`
+	Apps     []AppTraffic `json:"apps"`
+}
+
+// TrafficReport 流量报告
+type TrafficReport struct {
+	From       time.Time     `json:"from"`
+	To         time.Time     `json:"to"`
+	Resolution Resolution    `json:"resolution"` // 报告使用的汇总粒度
+	Total      TrafficVolume `json:"total"`      // 用户流量合计
+	Peak       TrafficPeak   `json:"peak"`
+	TopUsers   []UserTraffic `json:"top_users"`
+	TopApps    []LineApps    `json:"top_apps"` // 按线路排序
+}
+
+// Report 生成[from,to)内的流量报告,top为各排行的条数(为0时为10)
+// 按起止时间的对齐情况自动选择最粗的可用粒度(1d,1h,5m)
+func (s *TrafficStore) Report(from, to time.Time, top int) (*TrafficReport, error) {
+	if top <= 0 {
+		top = 10
+	}
+	from, to = from.In(s.location()), to.In(s.location())
+	res := Res5m
+	for _, r := range []Resolution{Res1d, Res1h} {
+		if r.bucket(from).Equal(from) && r.bucket(to).Equal(to) {
+			res = r
+			break
+		}
+	}
+	rollups, err := s.Query(res, from, to)
+	if err != nil {
+		return nil, err
+	}
+	all := newTrafficRollup(res, from)
+	for _, r := range rollups {
+		all.merge(r)
+	}
+	report := &TrafficReport{
+		From:       from,
+		To:         to,
+		Resolution: res,
+		Peak: TrafficPeak{
+			At:   all.PeakAt,
+			Peak: all.Peak,
+			Recv: all.RecvPeak,
+			Send: all.SendPeak,
+			Unit: all.Unit,
+		},
+	}
+	for _, u := range all.Users {
+		report.TopUsers = append(report.TopUsers, *u)
+		report.Total.add(u.TrafficVolume)
+	}
+	sort.Slice(report.TopUsers, func(i, j int) bool {
+		if report.TopUsers[i].Total != report.TopUsers[j].Total {
+			return report.TopUsers[i].Total > report.TopUsers[j].Total
+		}
+		return report.TopUsers[i].Name < report.TopUsers[j].Name
+	})
+	if len(report.TopUsers) > top {
+		report.TopUsers = report.TopUsers[:top]
+	}
+	lines := make(map[int]*LineApps)
+	for _, a := range all.Apps {
+		l, ok := lines[a.Line]
+		if !ok {
+			l = &LineApps{Line: a.Line}
+			lines[a.Line] = l
+		}
+		if a.LineName != "" {
+			l.LineName = a.LineName
+		}
+		l.Apps = append(l.Apps, *a)
+	}
+	for _, l := range lines {
+		sort.Slice(l.Apps, func(i, j int) bool {
+			if l.Apps[i].Total != l.Apps[j].Total {
+				return l.Apps[i].Total > l.Apps[j].Total
+			}
+			return l.Apps[i].App < l.Apps[j].App
+		})
+		if len(l.Apps) > top {
+			l.Apps = l.Apps[:top]
+		}
+		report.TopApps = append(report.TopApps, *l)
+	}
+	sort.Slice(report.TopApps, func(i, j int) bool { return report.TopApps[i].Line < report.TopApps[j].Line })
+	return report, nil
+}
+
+// WriteCSV 输出CSV格式报告,分为两个以空行分隔的表:
+// 吞吐量峰值表(at/unit/send/recv/total),排行表(每行首列为分类user/app)
+func (r *TrafficReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	i64 := func(v int64) string { return strconv.FormatInt(v, 10) }
+	peak := [][]string{
+		{"peak_at", "unit", "send", "recv", "total"},
+		{r.Peak.At.Format(acTimeLayout), r.Peak.Unit, i64(r.Peak.Send), i64(r.Peak.Recv), i64(r.Peak.Peak)},
+	}
+	if err := cw.WriteAll(peak); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return err
+	}
+	rows := [][]string{
+		{"section", "rank", "name", "group_or_line", "up", "down", "total"},
+	}
+	for i, u := range r.TopUsers {
+		rows = append(rows, []string{"user", strconv.Itoa(i + 1), u.Name, u.Group, i64(u.Up), i64(u.Down), i64(u.Total)})
+	}
+	for _, l := range r.TopApps {
+		line := l.LineName
+		if line == "" {
+			line = strconv.Itoa(l.Line)
+		}
+		for i, a := range l.Apps {
+			rows = append(rows, []string{"app", strconv.Itoa(i + 1), a.App, line, i64(a.Up), i64(a.Down), i64(a.Total)})
+		}
+	}
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+var acTrafficReportHTML = template.Must(template.New("report").Funcs(template.FuncMap{
+	"fmtTime": func(t time.Time) string { return t.Format(acTimeLayout) },
+	"bytes":   acFormatBytes,
+	"inc":     func(i int) int { return i + 1 },
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>流量报告 {{fmtTime .From}} - {{fmtTime .To}}</title>
+<style>
+body{font-family:sans-serif}
+table{border-collapse:collapse;margin-bottom:1.5em}
+th,td{border:1px solid #999;padding:.25em .75em;text-align:right}
+th:nth-child(2),td:nth-child(2),th:nth-child(3),td:nth-child(3){text-align:left}
+</style></head><body>
+<h2>流量报告 {{fmtTime .From}} - {{fmtTime .To}} ({{.Resolution}})</h2>
+<p>用户流量合计: {{bytes .Total.Total}} (上行 {{bytes .Total.Up}}, 下行 {{bytes .Total.Down}})</p>
+<p>吞吐量峰值: {{.Peak.Peak}} {{.Peak.Unit}} @ {{fmtTime .Peak.At}} (接收峰值 {{.Peak.Recv}}, 发送峰值 {{.Peak.Send}})</p>
+<h3>用户流量排行</h3>
+<table><tr><th>#</th><th>用户</th><th>组</th><th>上行</th><th>下行</th><th>合计</th></tr>
+{{range $i, $u := .TopUsers}}<tr><td>{{inc $i}}</td><td>{{$u.Name}}</td><td>{{$u.Group}}</td><td>{{bytes $u.Up}}</td><td>{{bytes $u.Down}}</td><td>{{bytes $u.Total}}</td></tr>
+{{end}}</table>
+{{range .TopApps}}<h3>应用流量排行 线路{{.Line}} {{.LineName}}</h3>
+<table><tr><th>#</th><th>应用</th><th>线路</th><th>上行</th><th>下行</th><th>合计</th></tr>
+{{range $i, $a := .Apps}}<tr><td>{{inc $i}}</td><td>{{$a.App}}</td><td>{{$a.LineName}}</td><td>{{bytes $a.Up}}</td><td>{{bytes $a.Down}}</td><td>{{bytes $a.Total}}</td></tr>
+{{end}}</table>
+{{end}}</body></html>
+`))
+
+// WriteHTML 输出HTML格式报告
+func (r *TrafficReport) WriteHTML(w io.Writer) error {
+	return acTrafficReportHTML.Execute(w, r)
+}
+
+// acFormatBytes 按1024进制格式化字节数
+func acFormatBytes(v int64) string {
+	const units = "KMGTPE"
+	if v < 1024 {
+		return strconv.FormatInt(v, 10) + " B"
+	}
+	f, i := float64(v)/1024, 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	return strconv.FormatFloat(f, 'f', 2, 64) + " " + units[i:i+1] + "iB"
+}
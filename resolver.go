@@ -0,0 +1,265 @@
+/**
+ * @Description: effective policy resolver for a single user
+ * @File:  resolver
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+)
+
+// ResolvedPolicy 用户适用的单条策略及来源
+type ResolvedPolicy struct {
+	Name      string `json:"name"`
+	Via       string `json:"via"`       // PolicyViaUser/PolicyViaGroup/PolicyViaSourceIP/PolicyViaLocation/PolicyViaTerminal
+	Detail    string `json:"detail"`    // 来源详情,如组路径,IP范围
+	Enabled   bool   `json:"enabled"`   // 策略是否启用
+	Expired   bool   `json:"expired"`   // 策略是否过期(仅上网策略)
+	Effective bool   `json:"effective"` // 是否生效(启用且未过期)
+}
+
+// EffectivePolicies 用户的生效策略说明,Net和Flux按优先顺序排列:
+// 直接关联,所在组(由近及远),源IP,位置,终端
+type EffectivePolicies struct {
+	User       string           `json:"user"`
+	FatherPath string           `json:"father_path"`
+	IP         string           `json:"ip,omitempty"` // 在线IP
+	Online     bool             `json:"online"`
+	Terminal   string           `json:"terminal,omitempty"`
+	Location   string           `json:"location,omitempty"`
+	Net        []ResolvedPolicy `json:"net"`
+	Flux       []ResolvedPolicy `json:"flux"`
+	Warnings   []string         `json:"warnings,omitempty"` // 获取部分信息失败的说明
+}
+
+// ResolveOption 策略解析选项
+type ResolveOption func(*acResolve)
+
+type acResolve struct {
+	index    *PolicyIndex
+	location string
+	terminal string
+}
+
+// ResolveWithIndex 使用已建立的策略索引,避免重复获取策略列表
+func ResolveWithIndex(idx *PolicyIndex) ResolveOption {
+	return func(r *acResolve) { r.index = idx }
+}
+
+// ResolveAtLocation 指定用户所在位置(在线用户信息中不包含位置)
+func ResolveAtLocation(location string) ResolveOption {
+	return func(r *acResolve) { r.location = location }
+}
+
+// ResolveOnTerminal 指定终端类型名称(在线用户信息中只有终端类型编号,不用于匹配)
+func ResolveOnTerminal(terminal string) ResolveOption {
+	return func(r *acResolve) { r.terminal = terminal }
+}
+
+// ResolvePolicies 解析用户(用户名或在线IP)的生效上网策略与流控策略
+// 关联关系由 PolicyIndex 得出,除在线信息和用户详情外不再逐个查询用户/组的策略
+func (ac *AC) ResolvePolicies(userOrIP string, opts ...ResolveOption) (*EffectivePolicies, error) {
+	if userOrIP == "" {
+		return nil, acErrArg()
+	}
+	var o acResolve
+	for _, opt := range opts {
+		opt(&o)
+	}
+	r := &EffectivePolicies{User: userOrIP, Location: o.location, Terminal: o.terminal}
+
+	// 在线信息
+	filter := &OnlineUserGetFilter{Type: "user", Value: []string{userOrIP}}
+	if ip := net.ParseIP(userOrIP); ip != nil {
+		filter = &OnlineUserGetFilter{Type: "ip", Value: []string{ip.String()}}
+	}
+	online, err := ac.OnlineUserGet(OnlineUserGet{Status: "all", Filter: filter})
+	if err != nil {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("get online user: %v", err))
+	} else {
+		for _, u := range online.Users {
+			if filter.Type == "ip" || u.Name == userOrIP {
+				r.User, r.FatherPath, r.IP, r.Online = u.Name, u.FatherPath, u.Ip, true
+				break
+			}
+		}
+	}
+	if filter.Type == "ip" && !r.Online {
+		return nil, fmt.Errorf("no online user with ip %s", userOrIP)
+	}
+
+	idx := o.index
+	if idx == nil {
+		if idx, err = ac.policyIndex(nil); err != nil {
+			return nil, err
+		}
+	}
+	// 用户详情(所在组,直接关联的策略,绑定IP),索引中没有该用户时加入
+	user := &UserDetail{Name: r.User, FatherPath: r.FatherPath}
+	if detail, err := ac.UserGet(r.User); err != nil {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("get user %s: %v", r.User, err))
+	} else if detail != nil {
+		user = detail
+		r.FatherPath = detail.FatherPath
+	}
+	if u := idx.byName[r.User]; u == nil || u.FatherPath != user.FatherPath {
+		idx = NewPolicyIndex(idx.Net, idx.Flux, []UserDetail{*user})
+	}
+
+	// 上网策略:直接关联,所在组(由近及远),源IP,位置,终端
+	var (
+		nets   acResolvedSet
+		groups []PolicyMatch
+		ips    []PolicyMatch
+	)
+	for _, m := range idx.ForUser(r.User) {
+		switch m.Via {
+		case PolicyViaGroup:
+			groups = append(groups, m)
+		case PolicyViaSourceIP:
+			ips = append(ips, m)
+		default:
+			nets.add(idx, ac, m.Policy.Name, m.Via, m.Detail)
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return len(groups[i].Detail) > len(groups[j].Detail) })
+	if ip := net.ParseIP(r.IP); ip != nil {
+		ips = append(idx.ForIP(ip), ips...)
+	}
+	for _, m := range append(groups, ips...) {
+		nets.add(idx, ac, m.Policy.Name, m.Via, m.Detail)
+	}
+	for _, p := range idx.Net {
+		if r.Location != "" && acContains(p.UserInfo.Location, r.Location) {
+			nets.add(idx, ac, p.PolicyInfo.Name, PolicyViaLocation, r.Location)
+		}
+		if r.Terminal != "" && acContains(p.UserInfo.Terminal, r.Terminal) {
+			nets.add(idx, ac, p.PolicyInfo.Name, PolicyViaTerminal, r.Terminal)
+		}
+	}
+	r.Net = nets.list
+
+	// 流控策略:顺序同上网策略
+	var (
+		flux       acResolvedSet
+		fluxGroups []FluxMatch
+		fluxIPs    []FluxMatch
+	)
+	for _, m := range idx.FluxForUser(r.User) {
+		switch m.Via {
+		case PolicyViaGroup:
+			fluxGroups = append(fluxGroups, m)
+		case PolicyViaSourceIP:
+			fluxIPs = append(fluxIPs, m)
+		default:
+			flux.addFlux(idx, m.Policy.Name, m.Via, m.Detail)
+		}
+	}
+	sort.SliceStable(fluxGroups, func(i, j int) bool { return len(fluxGroups[i].Detail) > len(fluxGroups[j].Detail) })
+	if ip := net.ParseIP(r.IP); ip != nil {
+		fluxIPs = append(idx.FluxForIP(ip), fluxIPs...)
+	}
+	for _, m := range append(fluxGroups, fluxIPs...) {
+		flux.addFlux(idx, m.Policy.Name, m.Via, m.Detail)
+	}
+	for _, p := range idx.Flux {
+		objects := acSplitList(p.Object)
+		if r.Location != "" && acContains(objects, r.Location) {
+			flux.addFlux(idx, p.Name, PolicyViaLocation, r.Location)
+		}
+		if r.Terminal != "" && acContains(objects, r.Terminal) {
+			flux.addFlux(idx, p.Name, PolicyViaTerminal, r.Terminal)
+		}
+	}
+	r.Flux = flux.list
+	return r, nil
+}
+
+// WriteText 输出文字说明
+func (e *EffectivePolicies) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "用户: %s  所在组: %s", e.User, e.FatherPath)
+	if e.Online {
+		fmt.Fprintf(&b, "  在线IP: %s", e.IP)
+	} else {
+		b.WriteString("  (不在线)")
+	}
+	b.WriteString("\n")
+	section := func(title string, list []ResolvedPolicy) {
+		fmt.Fprintf(&b, "%s:\n", title)
+		if len(list) == 0 {
+			b.WriteString("  (无)\n")
+		}
+		for i, p := range list {
+			state := "生效"
+			switch {
+			case p.Expired:
+				state = "已过期"
+			case !p.Enabled:
+				state = "已禁用"
+			}
+			fmt.Fprintf(&b, "  %d. %s [%s] 来源: %s %s\n", i+1, p.Name, state, p.Via, p.Detail)
+		}
+	}
+	section("上网策略", e.Net)
+	section("流控策略", e.Flux)
+	for _, warn := range e.Warnings {
+		fmt.Fprintf(&b, "警告: %s\n", warn)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func acContains(list []string, v string) bool {
+	for _, s := range list {
+		if strings.TrimSpace(s) == v {
+			return true
+		}
+	}
+	return false
+}
+
+// acResolvedSet 按策略名去重,保留最先出现(优先级最高)的来源
+type acResolvedSet struct {
+	list []ResolvedPolicy
+	seen map[string]bool
+}
+
+func (s *acResolvedSet) push(p ResolvedPolicy) {
+	if s.seen == nil {
+		s.seen = make(map[string]bool)
+	}
+	if s.seen[p.Name] {
+		return
+	}
+	s.seen[p.Name] = true
+	s.list = append(s.list, p)
+}
+
+func (s *acResolvedSet) add(idx *PolicyIndex, ac *AC, name, via, detail string) {
+	p := ResolvedPolicy{Name: name, Via: via, Detail: detail}
+	if i := idx.netIndex(name); i >= 0 {
+		info := idx.Net[i].PolicyInfo
+		p.Enabled, p.Expired = info.Status, ac.policyExpired(info.Expire)
+	}
+	p.Effective = p.Enabled && !p.Expired
+	s.push(p)
+}
+
+func (s *acResolvedSet) addFlux(idx *PolicyIndex, name, via, detail string) {
+	p := ResolvedPolicy{Name: name, Via: via, Detail: detail}
+	for _, f := range idx.Flux {
+		if f.Name == name {
+			p.Enabled = f.Status
+			break
+		}
+	}
+	p.Effective = p.Enabled
+	s.push(p)
+}
@@ -0,0 +1,64 @@
+package sangfor
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestResolvePolicies(t *testing.T) {
+	ac, d := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		switch endpoint {
+		case acOnlineUsers:
+			return map[string]interface{}{"count": 1, "users": []map[string]interface{}{
+				{"name": "zhangsan", "father_path": "/dev/backend", "ip": "10.0.0.5", "terminal": 1},
+			}}, nil
+		case acUser:
+			return map[string]interface{}{"name": "zhangsan", "father_path": "/dev/backend",
+				"policy": []map[string]interface{}{{"name": "direct"}}}, nil
+		case acNetPolicy:
+			return []map[string]interface{}{
+				{"policy_info": map[string]interface{}{"name": "direct", "status": true}},
+				{"policy_info": map[string]interface{}{"name": "root", "status": true}, "user_info": map[string]interface{}{"ou": []string{"/"}}},
+				{"policy_info": map[string]interface{}{"name": "dev", "status": false}, "user_info": map[string]interface{}{"ou": []string{"/dev"}}},
+				{"policy_info": map[string]interface{}{"name": "backend", "status": true}, "user_info": map[string]interface{}{"ou": []string{"/dev/backend"}}},
+				{"policy_info": map[string]interface{}{"name": "lan", "status": true}, "user_info": map[string]interface{}{"sourceip": []string{"10.0.0.0/24"}}},
+			}, nil
+		case acFluxPolicy:
+			return []map[string]interface{}{
+				{"name": "flux-dev", "object": "/dev", "status": true},
+				{"name": "flux-user", "object": "lisi, /dev/backend/zhangsan", "status": true},
+			}, nil
+		}
+		return nil, errors.New("unexpected request " + endpoint)
+	})
+	r, err := ac.ResolvePolicies("10.0.0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Warnings) > 0 || r.User != "zhangsan" || r.Terminal != "" {
+		t.Fatalf("resolved %+v", r)
+	}
+	wantNet := []struct{ name, via string }{
+		{"direct", PolicyViaUser}, {"backend", PolicyViaGroup}, {"dev", PolicyViaGroup}, {"root", PolicyViaGroup}, {"lan", PolicyViaSourceIP},
+	}
+	if len(r.Net) != len(wantNet) {
+		t.Fatalf("net = %+v", r.Net)
+	}
+	for i, w := range wantNet {
+		if r.Net[i].Name != w.name || r.Net[i].Via != w.via {
+			t.Fatalf("net[%d] = %+v, want %s via %s", i, r.Net[i], w.name, w.via)
+		}
+	}
+	if r.Net[2].Effective {
+		t.Fatal("disabled policy dev should not be effective")
+	}
+	if len(r.Flux) != 2 || r.Flux[0].Name != "flux-user" || r.Flux[1].Name != "flux-dev" {
+		t.Fatalf("flux = %+v", r.Flux)
+	}
+	for _, c := range d.calls {
+		if c == acUserNetPolicy || c == acGroupNetPolicy || c == acUserFluxPolicy {
+			t.Fatalf("unexpected per-user/group policy query %s", c)
+		}
+	}
+}
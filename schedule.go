@@ -0,0 +1,560 @@
+/**
+ * @Description: scheduled operations against the ac
+ * @File:  schedule
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MissedPolicy 错过执行时间(进程未运行或被挂起)时的处理方式
+type MissedPolicy string
+
+const (
+	MissedSkip    MissedPolicy = "skip"     // 跳过错过的执行,等待下一次触发(默认)
+	MissedRunOnce MissedPolicy = "run_once" // 立即补执行一次(多次错过也只补一次)
+)
+
+// Operation 定时执行的AC操作
+type Operation interface {
+	Describe() string               // 操作描述,用于预览和历史记录
+	Execute(ac *AC) (string, error) // 执行操作,返回结果描述
+}
+
+// GroupNetPolicyOp 设置组上网策略(GroupNetPolicySet)
+type GroupNetPolicyOp struct {
+	GroupPolicySet
+}
+
+func (op GroupNetPolicyOp) Describe() string {
+	return fmt.Sprintf("GroupNetPolicySet %s %s %s", op.Opr, op.Group, strings.Join(op.Policy, ","))
+}
+
+func (op GroupNetPolicyOp) Execute(ac *AC) (string, error) {
+	return ac.GroupNetPolicySet(op.GroupPolicySet)
+}
+
+// OnlineUserKickOp 强制注销在线用户(OnlineUserKick)
+// 指定IP时注销该IP,指定Group时注销该组(含子组)下所有在线用户
+type OnlineUserKickOp struct {
+	IP    string `json:"ip,omitempty"`
+	Group string `json:"group,omitempty"`
+}
+
+func (op OnlineUserKickOp) Describe() string {
+	if op.IP != "" {
+		return "OnlineUserKick ip " + op.IP
+	}
+	return "OnlineUserKick group " + op.Group
+}
+
+func (op OnlineUserKickOp) Execute(ac *AC) (string, error) {
+	if op.IP != "" {
+		return "kicked " + op.IP, ac.OnlineUserKick(op.IP)
+	}
+	if !strings.HasPrefix(op.Group, "/") {
+		return "", acErrArg()
+	}
+	var (
+		kicked []string
+		errs   []string
+		tried  = make(map[string]bool)
+	)
+	// 在线用户接口不支持分页,每次最多返回100个用户,先按组名过滤查询(模糊匹配),再不过滤查询以覆盖子组,
+	// 每轮注销后重新查询,直到不再出现新的组内用户
+	filters := []*OnlineUserGetFilter{nil}
+	if name := path.Base(op.Group); name != "/" {
+		filters = []*OnlineUserGetFilter{{Type: "user", Value: []string{name}}, nil}
+	}
+	for _, filter := range filters {
+		for {
+			online, err := acOnlineUsersAll(ac, filter)
+			if err != nil {
+				return "", err
+			}
+			found := false
+			for _, u := range online {
+				if !acInGroup(u.FatherPath, op.Group) || tried[u.Name+"@"+u.Ip] {
+					continue
+				}
+				found, tried[u.Name+"@"+u.Ip] = true, true
+				if err = ac.OnlineUserKick(u.Ip); err != nil {
+					errs = append(errs, fmt.Sprintf("%s(%s): %v", u.Name, u.Ip, err))
+					continue
+				}
+				kicked = append(kicked, u.Ip)
+			}
+			if !found {
+				break
+			}
+		}
+	}
+	result := fmt.Sprintf("kicked %d users in %s", len(kicked), op.Group)
+	if len(errs) > 0 {
+		return result, fmt.Errorf("kick users: %s", strings.Join(errs, "; "))
+	}
+	return result, nil
+}
+
+// acOnlineTerminals 在线用户的终端类型,查询结果达到上限时按终端类型分别查询
+var acOnlineTerminals = []string{"pc", "mobile", "multi", "iot", "armarium", "custom"}
+
+// acOnlineUsersAll 按filter(可为nil)获取在线用户,结果不完整(超过100个)时按终端类型分别查询并合并
+func acOnlineUsersAll(ac *AC, filter *OnlineUserGetFilter) ([]OnlineUser, error) {
+	online, err := ac.OnlineUserGet(OnlineUserGet{Status: "all", Terminal: "all", Filter: filter})
+	if err != nil {
+		return nil, err
+	}
+	if online.Count <= len(online.Users) {
+		return online.Users, nil
+	}
+	var (
+		r    = online.Users
+		seen = make(map[string]bool, len(r))
+	)
+	for _, u := range r {
+		seen[u.Name+"@"+u.Ip] = true
+	}
+	for _, terminal := range acOnlineTerminals {
+		part, err := ac.OnlineUserGet(OnlineUserGet{Status: "all", Terminal: terminal, Filter: filter})
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range part.Users {
+			if !seen[u.Name+"@"+u.Ip] {
+				r, seen[u.Name+"@"+u.Ip] = append(r, u), true
+			}
+		}
+	}
+	return r, nil
+}
+
+// UserModOp 修改用户信息(UserMod),如禁用账号
+type UserModOp struct {
+	UserMod
+}
+
+func (op UserModOp) Describe() string {
+	return "UserMod " + op.Name
+}
+
+func (op UserModOp) Execute(ac *AC) (string, error) {
+	return ac.UserMod(op.UserMod)
+}
+
+// OperationFunc 自定义操作
+type OperationFunc struct {
+	Name string
+	Func func(ac *AC) (string, error)
+}
+
+func (op OperationFunc) Describe() string {
+	return op.Name
+}
+
+func (op OperationFunc) Execute(ac *AC) (string, error) {
+	return op.Func(ac)
+}
+
+// Job 定时任务
+type Job struct {
+	Name     string        // 任务名(唯一)
+	Schedule *CronSchedule // 按设备时区(AC.Location)计算触发时间
+	Op       Operation
+	Missed   MissedPolicy // 错过执行时的处理方式
+}
+
+// JobConfig 声明式任务配置(JSON),Op取值group_net_policy/online_user_kick/user_mod
+// e.g:{"name":"exam-on","cron":"0 8 * * 1-5","op":"group_net_policy","group_policy":{"opr":"add","group":"/students","policy":["考试"]}}
+type JobConfig struct {
+	Name        string            `json:"name"`
+	Cron        string            `json:"cron"`
+	Missed      MissedPolicy      `json:"missed,omitempty"`
+	Op          string            `json:"op"`
+	GroupPolicy *GroupPolicySet   `json:"group_policy,omitempty"`
+	Kick        *OnlineUserKickOp `json:"kick,omitempty"`
+	UserMod     *UserMod          `json:"user_mod,omitempty"`
+}
+
+// Job 将配置转换为任务
+func (c JobConfig) Job() (Job, error) {
+	sched, err := ParseCron(c.Cron)
+	if err != nil {
+		return Job{}, err
+	}
+	job := Job{Name: c.Name, Schedule: sched, Missed: c.Missed}
+	switch {
+	case c.Op == "group_net_policy" && c.GroupPolicy != nil:
+		job.Op = GroupNetPolicyOp{*c.GroupPolicy}
+	case c.Op == "online_user_kick" && c.Kick != nil:
+		job.Op = *c.Kick
+	case c.Op == "user_mod" && c.UserMod != nil:
+		job.Op = UserModOp{*c.UserMod}
+	default:
+		return Job{}, fmt.Errorf("job %s: invalid op %q or missing op arguments", c.Name, c.Op)
+	}
+	return job, nil
+}
+
+// ParseJobs 解析JSON数组格式的声明式任务配置
+func ParseJobs(data []byte) ([]Job, error) {
+	var configs []JobConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, err
+	}
+	jobs := make([]Job, 0, len(configs))
+	for _, c := range configs {
+		job, err := c.Job()
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// JobRun 任务执行记录
+type JobRun struct {
+	Job       string    `json:"job"`
+	Operation string    `json:"operation"`
+	Scheduled time.Time `json:"scheduled"`         // 计划执行时间
+	Started   time.Time `json:"started"`           // 实际开始时间
+	Finished  time.Time `json:"finished"`          // 结束时间
+	Result    string    `json:"result,omitempty"`  // 执行结果
+	Error     string    `json:"error,omitempty"`   // 错误信息
+	Missed    bool      `json:"missed,omitempty"`  // 是否为错过后的补执行
+	DryRun    bool      `json:"dry_run,omitempty"` // 是否为演练(未实际执行)
+}
+
+// PlannedRun 预览的计划执行
+type PlannedRun struct {
+	Job       string    `json:"job"`
+	Operation string    `json:"operation"`
+	At        time.Time `json:"at"`
+}
+
+// Scheduler 定时任务调度器
+type Scheduler struct {
+	AC           *AC
+	DryRun       bool          // 演练模式,只记录历史不实际执行
+	HistoryLimit int           // 内存中保留的历史条数,为0时为100
+	StatePath    string        // 各任务最近执行时间的持久化文件,为空时不持久化(重启后无法识别错过的执行)
+	Tolerance    time.Duration // 超过计划时间多久视为错过,为0时为1分钟
+	OnRun        func(run JobRun)
+
+	mu      sync.Mutex
+	jobs    []Job
+	history []JobRun
+	lastRun map[string]time.Time
+	wake    chan struct{}
+}
+
+// NewScheduler 创建调度器
+func NewScheduler(ac *AC, jobs ...Job) (*Scheduler, error) {
+	s := &Scheduler{AC: ac}
+	for _, job := range jobs {
+		if err := s.Add(job); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Add 添加任务
+func (s *Scheduler) Add(job Job) error {
+	if job.Name == "" || job.Schedule == nil || job.Op == nil {
+		return acErrArg()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		if j.Name == job.Name {
+			return fmt.Errorf("job %s already exists", job.Name)
+		}
+	}
+	s.jobs = append(s.jobs, job)
+	if s.wake != nil {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Preview 预览[from,until]内的计划执行(不执行任何操作)
+func (s *Scheduler) Preview(from, until time.Time) []PlannedRun {
+	s.mu.Lock()
+	jobs := append([]Job(nil), s.jobs...)
+	s.mu.Unlock()
+	var plans []PlannedRun
+	for _, job := range jobs {
+		for t := s.next(job, from.Add(-time.Minute)); !t.IsZero() && !t.After(until); t = s.next(job, t) {
+			if t.Before(from) {
+				continue
+			}
+			plans = append(plans, PlannedRun{Job: job.Name, Operation: job.Op.Describe(), At: t})
+		}
+	}
+	sort.SliceStable(plans, func(i, j int) bool { return plans[i].At.Before(plans[j].At) })
+	return plans
+}
+
+// History 返回执行历史(按时间先后)
+func (s *Scheduler) History() []JobRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]JobRun(nil), s.history...)
+}
+
+// RunNow 立即执行指定任务
+func (s *Scheduler) RunNow(name string) (JobRun, error) {
+	s.mu.Lock()
+	var job *Job
+	for i := range s.jobs {
+		if s.jobs[i].Name == name {
+			job = &s.jobs[i]
+		}
+	}
+	s.mu.Unlock()
+	if job == nil {
+		return JobRun{}, fmt.Errorf("job %s not found", name)
+	}
+	run, err := s.execute(*job, time.Now(), false)
+	if err != nil {
+		return run, err
+	}
+	if run.Error != "" {
+		return run, errors.New(run.Error)
+	}
+	return run, nil
+}
+
+// Run 运行调度,直到ctx结束或持久化执行记录失败
+// 启动时根据持久化的最近执行时间识别错过的执行,按任务的 MissedPolicy 处理,运行中 Add 的任务同样会被调度
+func (s *Scheduler) Run(ctx context.Context) error {
+	if err := s.loadState(); err != nil {
+		return err
+	}
+	var (
+		wake = s.wakeup()
+		next = make(map[string]time.Time)
+		now  = time.Now()
+	)
+	for _, job := range s.snapshot() {
+		if last, ok := s.last(job.Name); ok {
+			if missed := s.next(job, last); !missed.IsZero() && missed.Before(now.Add(-s.tolerance())) {
+				if err := s.missed(job, missed); err != nil {
+					return err
+				}
+			}
+		}
+		next[job.Name] = s.next(job, now)
+	}
+	for {
+		var (
+			earliest time.Time
+			due      []Job
+			jobs     = s.snapshot()
+		)
+		now = time.Now()
+		for _, job := range jobs {
+			if _, ok := next[job.Name]; !ok {
+				next[job.Name] = s.next(job, now)
+			}
+			if t := next[job.Name]; !t.IsZero() && (earliest.IsZero() || t.Before(earliest)) {
+				earliest = t
+			}
+		}
+		var (
+			timer   *time.Timer
+			timeout <-chan time.Time
+		)
+		if !earliest.IsZero() {
+			timer = time.NewTimer(time.Until(earliest))
+			timeout = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			acStopTimer(timer)
+			return ctx.Err()
+		case <-wake:
+			acStopTimer(timer)
+			continue
+		case <-timeout:
+		}
+		now = time.Now()
+		for _, job := range jobs {
+			if t := next[job.Name]; !t.IsZero() && !t.After(now) {
+				due = append(due, job)
+			}
+		}
+		for _, job := range due {
+			var (
+				scheduled = next[job.Name]
+				err       error
+			)
+			// 定时器被系统挂起等原因严重延迟时按错过处理
+			if now.Sub(scheduled) > s.tolerance() {
+				err = s.missed(job, scheduled)
+			} else {
+				_, err = s.execute(job, scheduled, false)
+			}
+			if err != nil {
+				return err
+			}
+			next[job.Name] = s.next(job, time.Now())
+		}
+	}
+}
+
+func acStopTimer(t *time.Timer) {
+	if t != nil {
+		t.Stop()
+	}
+}
+
+// snapshot 返回当前任务列表的副本
+func (s *Scheduler) snapshot() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Job(nil), s.jobs...)
+}
+
+// wakeup 返回 Add 新任务时的通知通道
+func (s *Scheduler) wakeup() chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wake == nil {
+		s.wake = make(chan struct{}, 1)
+	}
+	return s.wake
+}
+
+func (s *Scheduler) missed(job Job, scheduled time.Time) error {
+	if job.Missed != MissedRunOnce {
+		return s.record(JobRun{
+			Job:       job.Name,
+			Operation: job.Op.Describe(),
+			Scheduled: scheduled,
+			Started:   time.Now(),
+			Finished:  time.Now(),
+			Result:    "skipped",
+			Missed:    true,
+		})
+	}
+	_, err := s.execute(job, scheduled, true)
+	return err
+}
+
+// execute 执行任务并记录,只在持久化执行记录失败时返回错误,任务本身的错误记录在 JobRun.Error 中
+func (s *Scheduler) execute(job Job, scheduled time.Time, missed bool) (JobRun, error) {
+	run := JobRun{
+		Job:       job.Name,
+		Operation: job.Op.Describe(),
+		Scheduled: scheduled,
+		Started:   time.Now(),
+		Missed:    missed,
+		DryRun:    s.DryRun,
+	}
+	if s.DryRun {
+		run.Result = "dry run"
+	} else {
+		result, err := job.Op.Execute(s.AC)
+		run.Result = result
+		if err != nil {
+			run.Error = err.Error()
+		}
+	}
+	run.Finished = time.Now()
+	return run, s.record(run)
+}
+
+func (s *Scheduler) record(run JobRun) error {
+	limit := s.HistoryLimit
+	if limit <= 0 {
+		limit = 100
+	}
+	s.mu.Lock()
+	s.history = append(s.history, run)
+	if len(s.history) > limit {
+		s.history = append(s.history[:0:0], s.history[len(s.history)-limit:]...)
+	}
+	if !run.DryRun {
+		if s.lastRun == nil {
+			s.lastRun = make(map[string]time.Time)
+		}
+		s.lastRun[run.Job] = run.Scheduled
+	}
+	state := make(map[string]time.Time, len(s.lastRun))
+	for k, v := range s.lastRun {
+		state[k] = v
+	}
+	s.mu.Unlock()
+	var err error
+	if s.StatePath != "" && !run.DryRun {
+		if err = acSaveJSON(s.StatePath, state); err != nil {
+			err = fmt.Errorf("save scheduler state: %w", err)
+		}
+	}
+	if s.OnRun != nil {
+		s.OnRun(run)
+	}
+	return err
+}
+
+func (s *Scheduler) loadState() error {
+	if s.StatePath == "" {
+		return nil
+	}
+	state := make(map[string]time.Time)
+	if _, err := acLoadJSON(s.StatePath, &state); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.lastRun = state
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Scheduler) last(name string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.lastRun[name]
+	return t, ok
+}
+
+// next 按设备时区计算任务在t之后的下一次触发时间
+func (s *Scheduler) next(job Job, t time.Time) time.Time {
+	loc := time.Local
+	if s.AC != nil {
+		loc = s.AC.location()
+	}
+	return job.Schedule.Next(t.In(loc))
+}
+
+func (s *Scheduler) tolerance() time.Duration {
+	if s.Tolerance <= 0 {
+		return time.Minute
+	}
+	return s.Tolerance
+}
+
+// acInGroup 组路径path是否为group本身或其子组
+func acInGroup(path, group string) bool {
+	if group == "/" || path == group {
+		return true
+	}
+	return strings.HasPrefix(path, strings.TrimSuffix(group, "/")+"/")
+}
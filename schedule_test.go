@@ -0,0 +1,106 @@
+package sangfor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchedulerRecordError(t *testing.T) {
+	cron, err := ParseCron("0 8 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewScheduler(nil, Job{Name: "noop", Schedule: cron, Op: OperationFunc{Name: "noop", Func: func(ac *AC) (string, error) {
+		return "ok", nil
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocker := filepath.Join(t.TempDir(), "blocker")
+	if err = ioutil.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.StatePath = filepath.Join(blocker, "state.json") // 上级路径是文件,无法写入
+	if _, err = s.RunNow("noop"); err == nil {
+		t.Fatal("want error when state cannot be saved")
+	}
+	if h := s.History(); len(h) != 1 || h[0].Result != "ok" {
+		t.Fatalf("history = %+v, want one ok run", h)
+	}
+}
+
+// TestOnlineUserKickGroupPaging 组外在线用户占满100个返回名额时,按组名过滤查询,注销后重新查询直到组内用户全部注销
+func TestOnlineUserKickGroupPaging(t *testing.T) {
+	var (
+		ips    []string              // 按设备返回顺序,组外用户在前
+		online = map[string]string{} // ip -> father_path
+	)
+	for i := 0; i < 250; i++ {
+		group := "/other"
+		if i >= 125 {
+			group = "/students/a"
+		}
+		ip := fmt.Sprintf("10.0.%d.%d", i/200, i%200)
+		ips, online[ip] = append(ips, ip), group
+	}
+	ac, _ := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		var body OnlineUserGet
+		if r.URL.Query().Get("_method") == "DELETE" {
+			var kick map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&kick)
+			delete(online, kick["ip"])
+			return "ok", nil
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		var (
+			users = make([]map[string]interface{}, 0, 100)
+			count int
+		)
+		for _, ip := range ips {
+			group, ok := online[ip]
+			if !ok || body.Filter != nil && !strings.Contains(group, body.Filter.Value[0]) {
+				continue
+			}
+			if count++; len(users) < 100 {
+				users = append(users, map[string]interface{}{"name": ip, "ip": ip, "father_path": group})
+			}
+		}
+		return map[string]interface{}{"count": count, "users": users}, nil
+	})
+	result, err := OnlineUserKickOp{Group: "/students"}.Execute(ac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, group := range online {
+		if group != "/other" {
+			t.Fatalf("%s in %s still online (%s)", ip, group, result)
+		}
+	}
+	if len(online) != 125 {
+		t.Fatalf("%d users online, want 125", len(online))
+	}
+}
+
+// TestSchedulerDeviceLocation cron表达式按设备时区计算
+func TestSchedulerDeviceLocation(t *testing.T) {
+	cron, err := ParseCron("0 8 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc := time.FixedZone("UTC+8", 8*3600)
+	s, err := NewScheduler(&AC{Location: loc}, Job{Name: "exam", Schedule: cron, Op: OperationFunc{Name: "noop"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2030, 1, 1, 1, 0, 0, 0, time.UTC)
+	plans := s.Preview(from, from.Add(24*time.Hour))
+	if want := time.Date(2030, 1, 2, 8, 0, 0, 0, loc); len(plans) != 1 || !plans[0].At.Equal(want) {
+		t.Fatalf("plans = %+v, want 08:00 device time (%v)", plans, want.UTC())
+	}
+}
@@ -0,0 +1,102 @@
+/**
+ * @Description: sangfor ac typed user search builders
+ * @File:  search
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import "time"
+
+const (
+	SearchTypeUser = "user" // 按用户名搜索(支持模糊搜索)
+	SearchTypeIP   = "ip"   // 按IP段搜索
+	SearchTypeMAC  = "mac"  // 按绑定MAC搜索
+)
+
+// UserStatus 搜索的用户状态
+type UserStatus string
+
+const (
+	UserStatusAll      UserStatus = "all"      // 启用和禁用
+	UserStatusEnabled  UserStatus = "enabled"  // 启用
+	UserStatusDisabled UserStatus = "disabled" // 禁用
+)
+
+// SearchOption 用户搜索扩展条件
+type SearchOption func(s *UserSearch)
+
+// SearchByName 按用户名搜索(支持模糊搜索),name为空表示搜索所有用户
+func SearchByName(name string, opts ...SearchOption) UserSearch {
+	return acNewSearch(SearchTypeUser, name, opts)
+}
+
+// SearchByIPRange 按IP段搜索用户
+func SearchByIPRange(r IPRange, opts ...SearchOption) UserSearch {
+	return acNewSearch(SearchTypeIP, r, opts)
+}
+
+// SearchByMAC 按绑定的MAC地址搜索用户
+func SearchByMAC(mac MAC, opts ...SearchOption) UserSearch {
+	return acNewSearch(SearchTypeMAC, mac.String(), opts)
+}
+
+func acNewSearch(searchType string, value interface{}, opts []SearchOption) UserSearch {
+	s := UserSearch{SearchType: searchType, SearchValue: value}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return s
+}
+
+// InGroup 只搜索指定组(以"/"开头)中的用户
+func InGroup(path string) SearchOption {
+	return func(s *UserSearch) {
+		s.Extend.FatherPath = path
+	}
+}
+
+// WithCustomAttr 按自定义属性搜索,AC不支持同时搜索多个自定义属性,多次调用以最后一次为准
+func WithCustomAttr(key, value string) SearchOption {
+	return func(s *UserSearch) {
+		s.Extend.CustomCfg = map[string]string{key: value}
+	}
+}
+
+// Status 按用户状态搜索
+func Status(status UserStatus) SearchOption {
+	return func(s *UserSearch) {
+		s.Extend.UserStatus = string(status)
+	}
+}
+
+// PublicOnly 只搜索允许多人同时使用的账号
+func PublicOnly() SearchOption {
+	return func(s *UserSearch) {
+		s.Extend.Public = true
+	}
+}
+
+// ExpiringBetween 搜索账号过期日期在[start,end]内的用户,设备只记录过期日期,按其自身时区取日期
+// 需要按设备时区并扣除时钟偏移时,使用 AC.ExpiringBetween
+func ExpiringBetween(start, end time.Time) SearchOption {
+	return acExpiringBetween(start.Format(acDateLayout), end.Format(acDateLayout), start.After(end))
+}
+
+// ExpiringBetween 搜索账号过期日期在[start,end]内的用户,时间加上时钟偏移后按设备时区取日期
+func (ac *AC) ExpiringBetween(start, end time.Time) SearchOption {
+	date := func(t time.Time) string {
+		return t.Add(ac.ClockOffset()).In(ac.location()).Format(acDateLayout)
+	}
+	return acExpiringBetween(date(start), date(end), start.After(end))
+}
+
+func acExpiringBetween(start, end string, swap bool) SearchOption {
+	if swap {
+		start, end = end, start
+	}
+	return func(s *UserSearch) {
+		s.Extend.Expire.Start = start
+		s.Extend.Expire.End = end
+	}
+}
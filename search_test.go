@@ -0,0 +1,59 @@
+package sangfor
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSearchBuildersJSON(t *testing.T) {
+	var (
+		loc    = time.FixedZone("CST", 8*3600)
+		start  = time.Date(2030, 1, 2, 23, 30, 0, 0, loc)
+		end    = time.Date(2030, 1, 9, 8, 0, 0, 0, loc)
+		r, _   = ParseIPRange("10.0.0.1-10.0.0.9")
+		mac, _ = ParseMAC("AA:BB:CC:DD:EE:FF")
+	)
+	cases := []struct {
+		name   string
+		search UserSearch
+		want   string
+	}{
+		{"name", SearchByName("alice"),
+			`{"search_type":"user","search_value":"alice","extend":{"expire":{}}}`},
+		{"ip range", SearchByIPRange(r),
+			`{"search_type":"ip","search_value":{"end":"10.0.0.9","start":"10.0.0.1"},"extend":{"expire":{}}}`},
+		{"mac", SearchByMAC(mac, PublicOnly()),
+			`{"search_type":"mac","search_value":"aa-bb-cc-dd-ee-ff","extend":{"public":true,"expire":{}}}`},
+		{"options", SearchByName("", InGroup("/guest"), WithCustomAttr("k", "v"), Status(UserStatusEnabled)),
+			`{"search_type":"user","search_value":"","extend":{"father_path":"/guest","custom_cfg":{"k":"v"},"user_status":"enabled","expire":{}}}`},
+		{"expiring swapped", SearchByName("", ExpiringBetween(end, start)),
+			`{"search_type":"user","search_value":"","extend":{"expire":{"start":"2030-01-02","end":"2030-01-09"}}}`},
+	}
+	for _, c := range cases {
+		if err := c.search.normalize(); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		b, err := json.Marshal(c.search)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if string(b) != c.want {
+			t.Fatalf("%s:\n got %s\nwant %s", c.name, b, c.want)
+		}
+	}
+}
+
+// TestACExpiringBetween 按设备时区及时钟偏移取过期日期
+func TestACExpiringBetween(t *testing.T) {
+	ac := &AC{Location: time.UTC, clock: &acClock{}}
+	ac.clock.result = &ClockSyncResult{Offset: 2 * time.Hour}
+	var (
+		start = time.Date(2030, 1, 1, 23, 0, 0, 0, time.UTC)
+		end   = time.Date(2030, 1, 5, 12, 0, 0, 0, time.UTC)
+		s     = SearchByName("", ac.ExpiringBetween(start, end))
+	)
+	if s.Extend.Expire.Start != "2030-01-02" || s.Extend.Expire.End != "2030-01-05" {
+		t.Fatalf("expire = %+v, want 2030-01-02..2030-01-05", s.Extend.Expire)
+	}
+}
@@ -0,0 +1,204 @@
+/**
+ * @Description: alternate request shapes and derived fallbacks for endpoints rejecting documented parameters
+ * @File:  shapes
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// sendAny 依次尝试多种请求格式,retry判断返回结果是否需要尝试下一种格式
+// 返回最后一次的结果及其是否仍被拒绝,请求本身失败(网络等错误)时直接返回错误
+func (ac *AC) sendAny(retry func(resp *acResp) bool, reqs ...*acReq) ([]byte, bool, error) {
+	var (
+		dataBytes []byte
+		err       error
+	)
+	for _, req := range reqs {
+		dataBytes, err = ac.send(req)
+		if err != nil {
+			return nil, false, err
+		}
+		var resp acResp
+		if json.Unmarshal(acFixJson(dataBytes), &resp) == nil && !retry(&resp) {
+			return dataBytes, false, nil
+		}
+	}
+	return dataBytes, true, nil
+}
+
+// acRetryRejected 只在AC提示请求格式不正确时尝试下一种格式,其他错误(如用户不存在,无权限)直接返回
+func acRetryRejected(resp *acResp) bool {
+	msg := strings.ToLower(resp.Message)
+	return resp.Code != 0 && (strings.Contains(msg, "格式") || strings.Contains(msg, "format"))
+}
+
+// acIsZero 是否为零值(空字符串,0,false,nil,空数组或对象),用于去掉请求体中的空字段
+func acIsZero(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map:
+		return rv.Len() == 0
+	}
+	return rv.IsZero()
+}
+
+// policyGetReqs 查询用户/组关联策略的请求格式,设备版本对应的格式在前
+func (ac *AC) policyGetReqs(uri, key, val string) []*acReq {
+	reqs := []*acReq{ac.adapter().policyGet(uri, key, val)}
+	for _, shape := range []func(uri, key, val string) *acReq{acPolicyGetQuery, acPolicyGetBody, acPolicyGetMethodQuery} {
+		reqs = acAppendReq(reqs, shape(uri, key, val))
+	}
+	return reqs
+}
+
+// acPolicyGetMethodQuery POST+_method=GET,参数仍在查询参数中
+func acPolicyGetMethodQuery(uri, key, val string) *acReq {
+	return &acReq{uri: uri, method: acPost, Query: map[string]string{"_method": acGet, key: val}}
+}
+
+// bindUserReqs 增加用户IP/MAC绑定的请求格式,设备版本对应的格式在前
+func (ac *AC) bindUserReqs(data BindUser) ([]*acReq, error) {
+	first := ac.adapter().bindUser
+	var reqs []*acReq
+	for _, shape := range []func(data BindUser) (map[string]interface{}, error){first, acBindUserFlat, acBindUserList} {
+		body, err := shape(data)
+		if err != nil {
+			return nil, err
+		}
+		reqs = acAppendReq(reqs, &acReq{uri: ac.baseUrl + acBindInfoUser, method: acPost, Data: body})
+	}
+	return reqs, nil
+}
+
+// acAppendReq 追加请求,忽略与已有请求相同的格式
+func acAppendReq(reqs []*acReq, req *acReq) []*acReq {
+	for _, r := range reqs {
+		if r.uri == req.uri && r.method == req.method && reflect.DeepEqual(r.Query, req.Query) && reflect.DeepEqual(r.Data, req.Data) {
+			return reqs
+		}
+	}
+	return append(reqs, req)
+}
+
+// userNetPolicyFallback 由用户详情中的策略得出用户关联的上网策略
+func (ac *AC) userNetPolicyFallback(username string) ([]string, error) {
+	u, err := ac.UserGet(username)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, errors.New(acErrNoData)
+	}
+	r := make([]string, 0, len(u.Policy))
+	for _, p := range u.Policy {
+		r = append(r, p.Name)
+	}
+	return r, nil
+}
+
+// userFluxPolicyFallback 由流控策略的适用对象(用户名或用户路径)得出用户关联的流控策略
+func (ac *AC) userFluxPolicyFallback(username string) ([]string, error) {
+	u, err := ac.UserGet(username)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, errors.New(acErrNoData)
+	}
+	policies, err := ac.PolicyFluxGet()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		r    = make([]string, 0)
+		path = acUserPath(u)
+	)
+	for _, p := range policies {
+		objects := acSplitList(p.Object)
+		if acContains(objects, u.Name) || acContains(objects, path) {
+			r = append(r, p.Name)
+		}
+	}
+	return r, nil
+}
+
+// groupNetPolicyFallback 由上网策略关联的组织结构得出组直接关联的上网策略
+func (ac *AC) groupNetPolicyFallback(path string) ([]string, error) {
+	policies, err := ac.PolicyNetGet()
+	if err != nil {
+		return nil, err
+	}
+	r := make([]string, 0)
+	for _, p := range policies {
+		if acContains(p.UserInfo.Ou, path) {
+			r = append(r, p.PolicyInfo.Name)
+		}
+	}
+	return r, nil
+}
+
+// bindUserSearchFallback 按用户名,IP或MAC搜索用户,由用户详情中的IP/MAC绑定信息得出绑定关系
+// 按用户名搜索为模糊搜索,只保留用户名完全相同的用户
+func (ac *AC) bindUserSearchFallback(val string) ([]BindUser, error) {
+	var (
+		search  = SearchByName(val)
+		ip, mac string
+	)
+	if m, ok := acSeparatedMAC(val); ok {
+		search, mac = SearchByMAC(m), m.String()
+	} else if r, err := ParseIPRange(val); err == nil {
+		search, ip = SearchByIPRange(r), r.String()
+	}
+	users, err := ac.UserSearch(search)
+	if err != nil {
+		return nil, err
+	}
+	r := make([]BindUser, 0)
+	for _, u := range users {
+		if ip == "" && mac == "" && u.Name != val {
+			continue
+		}
+		for _, cfg := range u.BindCfg {
+			if (ip != "" && cfg["ip"] != ip) || (mac != "" && !strings.EqualFold(cfg["mac"], mac)) {
+				continue
+			}
+			r = append(r, ac.bindUserFromCfg(u.Name, cfg))
+		}
+	}
+	return r, nil
+}
+
+// bindUserFromCfg 由用户详情中的单条绑定信息(ip,mac,out_time,bindgoal,desc)得出绑定关系
+func (ac *AC) bindUserFromCfg(name string, cfg map[string]string) BindUser {
+	b := BindUser{Name: name, Enable: true, Desc: cfg["desc"]}
+	switch ip, mac := cfg["ip"], cfg["mac"]; {
+	case ip != "" && mac != "":
+		b.AddrType, b.Addr = "ipmac", ip+"+"+mac
+	case mac != "":
+		b.AddrType, b.Addr = "mac", mac
+	default:
+		b.AddrType, b.Addr = "ip", ip
+	}
+	goal := cfg["bindgoal"]
+	b.Limitlogon = strings.Contains(goal, "loginlimit")
+	b.Noauth.Enable = strings.Contains(goal, "noauth")
+	if out := cfg["out_time"]; out != "" {
+		if t, err := ac.ParseDeviceTime(out); err == nil {
+			if len(out) == len(acDateLayout) {
+				t = t.AddDate(0, 0, 1) // 只有日期时为当天结束
+			}
+			b.Noauth.ExpireTime = int(t.Unix())
+		}
+	}
+	return b
+}
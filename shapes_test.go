@@ -0,0 +1,154 @@
+package sangfor
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsZero(t *testing.T) {
+	cases := []struct {
+		v    interface{}
+		want bool
+	}{
+		{nil, true}, {"", true}, {false, true}, {0.0, true}, {0, true},
+		{[]interface{}{}, true}, {map[string]interface{}{}, true},
+		{"a", false}, {true, false}, {1.5, false}, {[]interface{}{"a"}, false},
+	}
+	for _, c := range cases {
+		if got := acIsZero(c.v); got != c.want {
+			t.Fatalf("acIsZero(%#v) = %v, want %v", c.v, got, c.want)
+		}
+	}
+}
+
+// TestPolicyGetRetry 只在设备提示格式错误时尝试其他格式,其他错误直接返回
+func TestPolicyGetRetry(t *testing.T) {
+	cases := []struct {
+		name  string
+		msg   string
+		calls int
+	}{
+		{"device error", "用户不存在", 1},
+		{"format rejected", "请求的接口数据格式不正确!", 3},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ac, d := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+				if endpoint == acUserNetPolicy {
+					return nil, errors.New(c.msg)
+				}
+				if endpoint == acUser {
+					return map[string]interface{}{"name": "a"}, nil
+				}
+				return "AC13.0.15.097", nil
+			})
+			_, err := ac.UserNetPolicyGet("a")
+			n := 0
+			for _, call := range d.calls {
+				if call == acUserNetPolicy {
+					n++
+				}
+			}
+			if n != c.calls {
+				t.Fatalf("policy queried %d times, want %d (err %v)", n, c.calls, err)
+			}
+			if c.calls == 1 && (err == nil || err.Error() != c.msg) {
+				t.Fatalf("err = %v, want device error", err)
+			}
+		})
+	}
+}
+
+// TestUserVerifyPasswordBody 密码只放在请求体中
+func TestUserVerifyPasswordBody(t *testing.T) {
+	var queries []string
+	ac, _ := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		queries = append(queries, r.URL.RawQuery)
+		if endpoint == acUser {
+			return nil, errors.New("请求的接口数据格式不正确!")
+		}
+		return "AC13.0.15.097", nil
+	})
+	if err := ac.UserVerifyPassword("a", "s3cret"); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("err = %v, want ErrUnsupported", err)
+	}
+	for _, q := range queries {
+		if strings.Contains(q, "s3cret") {
+			t.Fatalf("password in query %q", q)
+		}
+	}
+}
+
+// TestBindUserSearchValue 不带分隔符的12位用户名(如工号)按用户名查询,带分隔符的MAC规范化后查询
+func TestBindUserSearchValue(t *testing.T) {
+	mac, _ := ParseMAC("AA:BB:CC:DD:EE:FF")
+	cases := []struct{ in, want string }{
+		{"201912345678", "201912345678"},
+		{"aabbccddeeff", "aabbccddeeff"},
+		{"AA:BB:CC:DD:EE:FF", mac.String()},
+	}
+	for _, c := range cases {
+		var got string
+		ac, _ := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+			if endpoint == acBindInfoUser {
+				got = r.URL.Query().Get("search")
+				return []interface{}{}, nil
+			}
+			return "AC13.0.15.097", nil
+		})
+		if _, err := ac.BindUserSearch(c.in); err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Fatalf("BindUserSearch(%q) searched %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+// TestBindUserSearchFallback 按用户名模糊搜索的结果只保留同名用户,过期时间按设备时钟偏移换算
+func TestBindUserSearchFallback(t *testing.T) {
+	ac, _ := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		if endpoint != acUser {
+			return nil, errors.New("unexpected request")
+		}
+		cfg := []interface{}{map[string]interface{}{"ip": "10.0.0.1", "bindgoal": "noauth", "out_time": "2030-01-02 03:04:05"}}
+		return []interface{}{
+			map[string]interface{}{"name": "201912345678", "bind_cfg": cfg},
+			map[string]interface{}{"name": "2019123456789", "bind_cfg": cfg},
+		}, nil
+	})
+	ac.clock.result = &ClockSyncResult{Offset: time.Hour}
+	binds, err := ac.bindUserSearchFallback("201912345678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(binds) != 1 || binds[0].Name != "201912345678" {
+		t.Fatalf("binds = %+v, want only 201912345678", binds)
+	}
+	out, _ := time.ParseInLocation(acTimeLayout, "2030-01-02 03:04:05", ac.location())
+	if want := int(out.Add(-time.Hour).Unix()); binds[0].Noauth.ExpireTime != want {
+		t.Fatalf("expire = %d, want %d", binds[0].Noauth.ExpireTime, want)
+	}
+}
+
+// TestPolicyFallbackNoData 用户详情为空(data为null)时返回错误
+func TestPolicyFallbackNoData(t *testing.T) {
+	ac, _ := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		switch endpoint {
+		case acUserNetPolicy, acUserFluxPolicy:
+			return nil, errors.New("请求的接口数据格式不正确!")
+		case acFluxPolicy:
+			return []interface{}{}, nil
+		}
+		return nil, nil
+	})
+	if _, err := ac.UserNetPolicyGet("a"); err == nil || err.Error() != acErrNoData {
+		t.Fatalf("net err = %v, want %q", err, acErrNoData)
+	}
+	if _, err := ac.UserFluxPolicyGet("a"); err == nil || err.Error() != acErrNoData {
+		t.Fatalf("flux err = %v, want %q", err, acErrNoData)
+	}
+}
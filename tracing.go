@@ -0,0 +1,91 @@
+/**
+ * @Description: tracing spans for ac calls
+ * @File:  tracing
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// 链路追踪属性名
+const (
+	AttrTarget         = "ac.target"          // 设备地址
+	AttrEndpoint       = "ac.endpoint"        // 接口路径
+	AttrMethod         = "http.method"        // HTTP方法
+	AttrMethodOverride = "ac.method_override" // _method指定的实际操作
+	AttrStatusCode     = "http.status_code"   // HTTP状态码
+	AttrResultCode     = "ac.result_code"     // AC返回码
+	AttrResponseSize   = "ac.response_size"   // 响应字节数
+)
+
+// Attribute 链路属性,Value为string,int,int64或bool
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Tracer 链路追踪接口,可桥接到OpenTelemetry:
+//
+//	type otelTracer struct{ t trace.Tracer }
+//	func (o otelTracer) Start(ctx context.Context, name string) (context.Context, sangfor.Span) {
+//		ctx, span := o.t.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient))
+//		return ctx, otelSpan{span}
+//	}
+type Tracer interface {
+	// Start 以ctx中的span为父节点创建span,返回包含新span的ctx
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span 单个调用的span
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// TracingInterceptor 为每个AC接口调用创建span,span名称为"AC <操作> <接口>"
+func TracingInterceptor(tracer Tracer) Interceptor {
+	return func(req *Request, next Handler) (*Response, error) {
+		ctx := req.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		ctx, span := tracer.Start(ctx, "AC "+req.Operation()+" "+req.Endpoint)
+		defer span.End()
+		attrs := []Attribute{
+			{AttrTarget, req.Target},
+			{AttrEndpoint, req.Endpoint},
+			{AttrMethod, req.Method},
+		}
+		if m, ok := req.Query["_method"]; ok {
+			attrs = append(attrs, Attribute{AttrMethodOverride, m})
+		}
+		span.SetAttributes(attrs...)
+
+		r := *req
+		r.Context = ctx
+		resp, err := next(&r)
+		if err != nil {
+			span.RecordError(err)
+			return resp, err
+		}
+		attrs = []Attribute{
+			{AttrStatusCode, resp.StatusCode},
+			{AttrResponseSize, len(resp.Body)},
+		}
+		var result acResp
+		if json.Unmarshal(resp.Body, &result) == nil {
+			attrs = append(attrs, Attribute{AttrResultCode, result.Code})
+		}
+		span.SetAttributes(attrs...)
+		if result.Code != 0 {
+			span.RecordError(errors.New(result.Message))
+		}
+		return resp, err
+	}
+}
@@ -0,0 +1,121 @@
+package sangfor
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type traceKey struct{}
+
+// testSpan 记录属性与错误
+type testSpan struct {
+	name   string
+	parent string
+	attrs  map[string]interface{}
+	errs   []error
+	ended  bool
+}
+
+func (s *testSpan) SetAttributes(attrs ...Attribute) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *testSpan) RecordError(err error) { s.errs = append(s.errs, err) }
+
+func (s *testSpan) End() { s.ended = true }
+
+// testTracer 以ctx中的span名称作为父节点
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	s := &testSpan{name: name, attrs: map[string]interface{}{}}
+	s.parent, _ = ctx.Value(traceKey{}).(string)
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, traceKey{}, name), s
+}
+
+// TestTracingInterceptor span名称,属性,父节点及AC返回码错误
+func TestTracingInterceptor(t *testing.T) {
+	ac, d := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		if r.URL.Query().Get("_method") == "DELETE" {
+			return nil, errors.New("user not found")
+		}
+		return "AC13.0.15.097", nil
+	})
+	tracer := &testTracer{}
+	ac.Use(TracingInterceptor(tracer))
+	var seen []string
+	ac.Use(func(req *Request, next Handler) (*Response, error) {
+		p, _ := req.Context.Value(traceKey{}).(string)
+		seen = append(seen, p)
+		return next(req)
+	})
+
+	c := ac.WithContext(context.WithValue(context.Background(), traceKey{}, "parent"))
+	if _, err := c.GetVersion(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UserDel("x"); err == nil {
+		t.Fatal("want device error")
+	}
+	if len(tracer.spans) != 2 {
+		t.Fatalf("spans = %d, want 2", len(tracer.spans))
+	}
+
+	target := strings.TrimPrefix(d.URL, "http://")
+	get, del := tracer.spans[0], tracer.spans[1]
+	if get.name != "AC GET status/version" || get.parent != "parent" || !get.ended || len(get.errs) != 0 {
+		t.Fatalf("get span = %+v", get)
+	}
+	want := map[string]interface{}{
+		AttrTarget: target, AttrEndpoint: "status/version", AttrMethod: "GET",
+		AttrStatusCode: http.StatusOK, AttrResultCode: 0,
+	}
+	for k, v := range want {
+		if get.attrs[k] != v {
+			t.Fatalf("get %s = %v, want %v", k, get.attrs[k], v)
+		}
+	}
+	if _, ok := get.attrs[AttrMethodOverride]; ok {
+		t.Fatalf("get span has %s", AttrMethodOverride)
+	}
+	if n, _ := get.attrs[AttrResponseSize].(int); n == 0 {
+		t.Fatalf("response size = %v", get.attrs[AttrResponseSize])
+	}
+
+	if del.name != "AC DELETE user" || del.attrs[AttrMethod] != "POST" || del.attrs[AttrMethodOverride] != "DELETE" {
+		t.Fatalf("del span = %+v", del)
+	}
+	if del.attrs[AttrResultCode] != 1 || len(del.errs) != 1 || del.errs[0].Error() != "user not found" {
+		t.Fatalf("del span result = %v, errs = %v", del.attrs[AttrResultCode], del.errs)
+	}
+
+	// 后续拦截器收到包含新span的ctx
+	if len(seen) != 2 || seen[0] != get.name || seen[1] != del.name {
+		t.Fatalf("inner ctx spans = %v", seen)
+	}
+}
+
+// TestTracingTransportError 请求失败时记录错误且不设置响应属性
+func TestTracingTransportError(t *testing.T) {
+	ac := NewAC("127.0.0.1:1", "secret")
+	tracer := &testTracer{}
+	ac.Use(TracingInterceptor(tracer))
+	if _, err := ac.GetVersion(); err == nil {
+		t.Fatal("want transport error")
+	}
+	s := tracer.spans[0]
+	if len(s.errs) != 1 || !s.ended || s.parent != "" {
+		t.Fatalf("span = %+v", s)
+	}
+	if _, ok := s.attrs[AttrStatusCode]; ok {
+		t.Fatalf("span has %s on transport error", AttrStatusCode)
+	}
+}
@@ -0,0 +1,258 @@
+/**
+ * @Description: sangfor ac guest access vouchers
+ * @File:  voucher
+ * @Version: 1.0.0
+ */
+
+package sangfor
+
+import (
+	"crypto/rand"
+	"fmt"
+	"html/template"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	acVoucherPrefix    = "guest"
+	acVoucherPassLen   = 8
+	acVoucherValidity  = 24 * time.Hour
+	acVoucherNameChars = "0123456789"
+	acVoucherNameLen   = 10 // 账号随机部分长度,10位数字可容纳大量凭证而不易重名
+	acVoucherRetries   = 3  // 账号已存在时重新生成账号的次数
+	acUserSearchLimit  = 100
+	acVoucherPassChars = "abcdefghjkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789" // 去除易混淆字符(0/O,1/l/I)
+)
+
+// Voucher 访客上网凭证
+type Voucher struct {
+	Name     string    `json:"name"`          // 访客账号
+	Password string    `json:"password"`      // 访客密码
+	Group    string    `json:"group"`         // 所在访客组
+	ExpireAt time.Time `json:"expire_at"`     // 过期时间
+	MAC      string    `json:"mac,omitempty"` // 绑定的MAC地址
+}
+
+// VoucherIssuer 访客凭证签发器,基于 UserAdd 在访客组中创建带随机密码和过期时间的临时账号
+type VoucherIssuer struct {
+	AC             *AC
+	Group          string        // 访客组(以"/"开头,需预先创建)
+	Prefix         string        // 账号前缀,为空时为"guest",回收时只处理带该前缀的账号
+	Validity       time.Duration // 有效期,为0时为24小时
+	PasswordLength int           // 密码长度,为0时为8位
+	Desc           string        // 账号描述
+}
+
+// Issue 签发一张访客凭证,传入mac时账号绑定该MAC并限制只能从该终端登录
+func (v *VoucherIssuer) Issue(mac ...MAC) (*Voucher, error) {
+	if v.AC == nil || !strings.HasPrefix(v.Group, "/") {
+		return nil, acErrArg()
+	}
+	passLen := v.PasswordLength
+	if passLen <= 0 {
+		passLen = acVoucherPassLen
+	}
+	pass, err := acRandomString(acVoucherPassChars, passLen)
+	if err != nil {
+		return nil, err
+	}
+	validity := v.Validity
+	if validity <= 0 {
+		validity = acVoucherValidity
+	}
+	voucher := &Voucher{
+		Password: pass,
+		Group:    v.Group,
+		ExpireAt: time.Now().Add(validity).Truncate(time.Second),
+	}
+	data := UserAdd{
+		FatherPath: v.Group,
+		Desc:       v.Desc,
+		ExpireTime: v.AC.FormatDeviceTime(voucher.ExpireAt),
+		Enable:     true,
+	}
+	data.SelfPass.Enable = true
+	data.SelfPass.Password = pass
+	if len(mac) > 0 && !mac[0].IsZero() {
+		voucher.MAC = mac[0].String()
+		data.BindCfg = []UserBindCfg{{
+			Mac:      voucher.MAC,
+			OutTime:  v.AC.FormatDeviceTime(voucher.ExpireAt)[:len(acDateLayout)],
+			Bindgoal: "loginlimit",
+			Desc:     "guest voucher",
+		}}
+	}
+	for i := 0; ; i++ {
+		name, err := acRandomString(acVoucherNameChars, acVoucherNameLen)
+		if err != nil {
+			return nil, err
+		}
+		voucher.Name = v.prefix() + name
+		data.Name = voucher.Name
+		if _, err = v.AC.UserAdd(data); err == nil {
+			break
+		} else if i >= acVoucherRetries || !acIsExists(err) {
+			return nil, err
+		}
+	}
+	return voucher, nil
+}
+
+// IssueBatch 批量签发n张访客凭证,出错时返回已签发成功的凭证及错误
+func (v *VoucherIssuer) IssueBatch(n int) ([]Voucher, error) {
+	vouchers := make([]Voucher, 0, n)
+	for i := 0; i < n; i++ {
+		voucher, err := v.Issue()
+		if err != nil {
+			return vouchers, err
+		}
+		vouchers = append(vouchers, *voucher)
+	}
+	return vouchers, nil
+}
+
+// CollectExpired 回收访客组中已过期的访客账号(通过 UserDel 删除),返回已删除的账号
+func (v *VoucherIssuer) CollectExpired() ([]string, error) {
+	if v.AC == nil || !strings.HasPrefix(v.Group, "/") {
+		return nil, acErrArg()
+	}
+	users, err := v.searchAll(v.prefix())
+	if err != nil {
+		return nil, err
+	}
+	var (
+		now     = time.Now()
+		deleted []string
+		errs    []string
+	)
+	for i := range users {
+		u := &users[i]
+		if !strings.HasPrefix(u.Name, v.prefix()) || u.FatherPath != v.Group {
+			continue
+		}
+		expire, ok, err := v.AC.UserExpireTime(u)
+		if err != nil || !ok || expire.After(now) {
+			continue
+		}
+		if _, err = v.AC.UserDel(u.Name); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", u.Name, err))
+			continue
+		}
+		deleted = append(deleted, u.Name)
+	}
+	if len(errs) > 0 {
+		return deleted, fmt.Errorf("delete expired guests: %s", strings.Join(errs, "; "))
+	}
+	return deleted, nil
+}
+
+// searchAll 搜索访客组中以prefix开头的账号
+// 搜索接口最多返回100个用户,结果达到上限时按下一位字符拆分前缀继续搜索
+func (v *VoucherIssuer) searchAll(prefix string) ([]UserDetail, error) {
+	users, err := v.AC.UserSearch(SearchByName(prefix, InGroup(v.Group)))
+	if err != nil {
+		return nil, err
+	}
+	if len(users) < acUserSearchLimit || len(prefix) >= len(v.prefix())+acVoucherNameLen {
+		return users, nil
+	}
+	var (
+		r    = make([]UserDetail, 0, len(users))
+		seen = make(map[string]bool)
+	)
+	for _, u := range users {
+		if u.Name == prefix { // 拆分后的前缀不会再搜索到与前缀同名的账号
+			r, seen[u.Name] = append(r, u), true
+		}
+	}
+	for _, c := range acVoucherNameChars {
+		sub, err := v.searchAll(prefix + string(c))
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range sub {
+			if !seen[u.Name] {
+				r, seen[u.Name] = append(r, u), true
+			}
+		}
+	}
+	return r, nil
+}
+
+func (v *VoucherIssuer) prefix() string {
+	if v.Prefix == "" {
+		return acVoucherPrefix
+	}
+	return v.Prefix
+}
+
+// WriteVouchersText 输出可打印的文本格式凭证
+func WriteVouchersText(w io.Writer, title string, vouchers []Voucher) error {
+	var b strings.Builder
+	for _, v := range vouchers {
+		b.WriteString("+------------------------------------+\n")
+		fmt.Fprintf(&b, "  %s\n", title)
+		fmt.Fprintf(&b, "  账号: %s\n", v.Name)
+		fmt.Fprintf(&b, "  密码: %s\n", v.Password)
+		fmt.Fprintf(&b, "  有效期至: %s\n", v.ExpireAt.Format(acTimeLayout))
+		if v.MAC != "" {
+			fmt.Fprintf(&b, "  绑定终端: %s\n", v.MAC)
+		}
+		b.WriteString("+------------------------------------+\n\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var acVoucherHTML = template.Must(template.New("vouchers").Funcs(template.FuncMap{
+	"fmtTime": func(t time.Time) string { return t.Format(acTimeLayout) },
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title>
+<style>
+body{font-family:sans-serif}
+.voucher{display:inline-block;width:16em;margin:.5em;padding:1em;border:1px dashed #333;page-break-inside:avoid}
+.voucher h3{margin:0 0 .5em}
+.voucher code{font-size:1.2em}
+</style></head><body>
+{{range .Vouchers}}<div class="voucher">
+<h3>{{$.Title}}</h3>
+<p>账号: <code>{{.Name}}</code></p>
+<p>密码: <code>{{.Password}}</code></p>
+<p>有效期至: {{fmtTime .ExpireAt}}</p>
+{{if .MAC}}<p>绑定终端: {{.MAC}}</p>{{end}}
+</div>
+{{end}}</body></html>
+`))
+
+// WriteVouchersHTML 输出可打印的HTML格式凭证
+func WriteVouchersHTML(w io.Writer, title string, vouchers []Voucher) error {
+	return acVoucherHTML.Execute(w, struct {
+		Title    string
+		Vouchers []Voucher
+	}{title, vouchers})
+}
+
+// acIsExists AC返回的错误是否为对象已存在
+func acIsExists(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "已存在") || strings.Contains(msg, "already exist")
+}
+
+// acRandomString 使用crypto/rand生成指定字符集的随机字符串
+func acRandomString(chars string, n int) (string, error) {
+	var (
+		b   = make([]byte, n)
+		max = big.NewInt(int64(len(chars)))
+	)
+	for i := range b {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = chars[idx.Int64()]
+	}
+	return string(b), nil
+}
@@ -0,0 +1,101 @@
+package sangfor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// voucherDevice 模拟访客组中的账号,搜索结果与AC一样最多返回100个,exists为前几次新增时提示账号已存在的次数
+func voucherDevice(t *testing.T, users map[string]string, exists int) (*AC, *[]string) {
+	var deleted []string
+	ac, _ := newTestAC(t, func(endpoint string, r *http.Request) (interface{}, error) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		switch r.URL.Query().Get("_method") {
+		case "":
+			if exists > 0 {
+				exists--
+				return nil, errors.New("用户已存在")
+			}
+			users[body["name"].(string)] = body["expire_time"].(string)
+			return "ok", nil
+		case "GET":
+			var list []map[string]interface{}
+			for name, expire := range users {
+				if strings.Contains(name, body["search_value"].(string)) && len(list) < acUserSearchLimit {
+					u := acTestUser(name, expire)
+					u["father_path"] = "/guest"
+					list = append(list, u)
+				}
+			}
+			return list, nil
+		case "DELETE":
+			deleted = append(deleted, body["name"].(string))
+			return "ok", nil
+		}
+		return nil, errors.New("unexpected request")
+	})
+	return ac, &deleted
+}
+
+func TestVoucherIssueRetry(t *testing.T) {
+	cases := []struct {
+		name   string
+		exists int
+		err    bool
+	}{
+		{"no conflict", 0, false},
+		{"retry on exists", acVoucherRetries, false},
+		{"give up", acVoucherRetries + 1, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			users := make(map[string]string)
+			ac, _ := voucherDevice(t, users, c.exists)
+			v, err := (&VoucherIssuer{AC: ac, Group: "/guest"}).Issue()
+			if (err != nil) != c.err {
+				t.Fatalf("err = %v, want error %v", err, c.err)
+			}
+			if c.err {
+				return
+			}
+			if len(v.Name) != len(acVoucherPrefix)+acVoucherNameLen || users[v.Name] == "" {
+				t.Fatalf("voucher %q not added: %v", v.Name, users)
+			}
+		})
+	}
+}
+
+func TestVoucherCollectExpiredPaging(t *testing.T) {
+	var (
+		users   = make(map[string]string)
+		expired = time.Now().AddDate(0, 0, -2).Format(acDateLayout)
+		valid   = time.Now().AddDate(0, 0, 2).Format(acDateLayout)
+		want    []string
+	)
+	for i := 0; i < 250; i++ {
+		name := fmt.Sprintf("guest%010d", i*7919)
+		if i%2 == 0 {
+			users[name] = expired
+			want = append(want, name)
+		} else {
+			users[name] = valid
+		}
+	}
+	ac, deleted := voucherDevice(t, users, 0)
+	got, err := (&VoucherIssuer{AC: ac, Group: "/guest"}).CollectExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if strings.Join(got, ",") != strings.Join(want, ",") || len(*deleted) != len(want) {
+		t.Fatalf("deleted %d accounts, want %d", len(got), len(want))
+	}
+}